@@ -0,0 +1,149 @@
+package router
+
+import (
+	"regexp"
+	"time"
+)
+
+// constraint restricts the values matched by a param, e.g. :id<int>.
+// A value failing the constraint is treated like a non-matching path.
+type constraint struct {
+	// pattern is the text between '<' and '>'
+	pattern string
+	match   func(string) bool
+}
+
+// equal reports whether c and o match the same values. A nil constraint
+// only equals nil.
+func (c *constraint) equal(o *constraint) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return c.pattern == o.pattern
+}
+
+// builtinConstraints are the named constraint types.
+// Every other constraint pattern is compiled as a regular expression, which
+// must match the whole param value.
+var builtinConstraints = map[string]func(string) bool{
+	"int":   isInt,
+	"uuid":  isUUID,
+	"alpha": isAlpha,
+	"date":  isDate,
+}
+
+func newConstraint(pattern string) (*constraint, error) {
+	if match := builtinConstraints[pattern]; match != nil {
+		return &constraint{pattern: pattern, match: match}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &constraint{pattern: pattern, match: re.MatchString}, nil
+}
+
+// constraintEnd returns the index after the '>' closing the constraint which
+// starts at path[i] == '<', or -1 if the constraint is not terminated.
+// Nested angle brackets, as used by named regexp groups, are balanced.
+func constraintEnd(path string, i int) int {
+	depth := 0
+	for ; i < len(path); i++ {
+		switch path[i] {
+		case '<':
+			depth++
+		case '>':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// wildcardEnd returns the end of the wildcard starting at path[i], which is
+// after its name and its constraint.
+func wildcardEnd(path string, i int) int {
+	end := i + 1
+	for end < len(path) && isNameByte(path[end]) {
+		end++
+	}
+	if end < len(path) && path[end] == '<' {
+		if e := constraintEnd(path, end); e > 0 {
+			end = e
+		}
+	}
+	return end
+}
+
+// isNameByte reports whether c can be part of a wildcard name. Names
+// consist of ASCII letters, digits and '_'.
+func isNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// splitWildcard splits a wildcard like ":id<int>" into its name "id" and
+// its constraint pattern "int".
+func splitWildcard(wildcard string) (name, pattern string) {
+	for i := 1; i < len(wildcard); i++ {
+		if wildcard[i] == '<' {
+			return wildcard[1:i], wildcard[i+1 : len(wildcard)-1]
+		}
+	}
+	return wildcard[1:], ""
+}
+
+func isInt(s string) bool {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAlpha(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDate(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
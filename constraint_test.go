@@ -0,0 +1,46 @@
+package router
+
+import "testing"
+
+func TestBuiltinConstraints(t *testing.T) {
+	tests := []struct {
+		constraint string
+		value      string
+		match      bool
+	}{
+		{"int", "42", true},
+		{"int", "-42", true},
+		{"int", "+42", true},
+		{"int", "", false},
+		{"int", "-", false},
+		{"int", "4x2", false},
+		{"uuid", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"uuid", "123E4567-E89B-12D3-A456-426614174000", true},
+		{"uuid", "123e4567-e89b-12d3-a456-42661417400", false},
+		{"uuid", "123e4567ae89b-12d3-a456-426614174000", false},
+		{"uuid", "123e4567-e89b-12d3-a456-42661417400g", false},
+		{"alpha", "abcXYZ", true},
+		{"alpha", "", false},
+		{"alpha", "abc1", false},
+		{"date", "2019-12-31", true},
+		{"date", "2019-13-01", false},
+		{"date", "20191231", false},
+		{"[a-z]+", "abc", true},
+		{"[a-z]+", "abc1", false},
+		{"a|b", "a", true},
+		{"a|b", "ab", false},
+	}
+	for _, test := range tests {
+		cons, err := newConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf("newConstraint(%q): unexpected error: %v", test.constraint, err)
+		}
+		if match := cons.match(test.value); match != test.match {
+			t.Errorf("<%s> matching %q = %t, want %t", test.constraint, test.value, match, test.match)
+		}
+	}
+
+	if _, err := newConstraint("[a-z"); err == nil {
+		t.Error("invalid regexp constraint did not fail")
+	}
+}
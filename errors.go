@@ -0,0 +1,57 @@
+package router
+
+import "errors"
+
+// ErrFrozen is returned when routes are registered on a frozen router, see
+// Router.Freeze.
+var ErrFrozen = errors.New("routes can not be changed on a frozen router")
+
+// InvalidPatternError is returned when a route path is malformed, e.g. it does
+// not begin with '/' or it contains an unnamed wildcard.
+type InvalidPatternError struct {
+	// Path is the rejected route path.
+	Path string
+	// Pos is the byte offset in Path where the problem starts.
+	Pos int
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *InvalidPatternError) Error() string {
+	return e.Reason + " in path '" + e.Path + "'"
+}
+
+// ConflictError is returned when a route can not be registered because it
+// conflicts with an already registered route. This is the case if a handler
+// is already registered for the same path, or if both routes have different
+// wildcards at the same position which would match the same values.
+type ConflictError struct {
+	// Path is the rejected route path.
+	Path string
+	// Existing is the path of the registered route Path conflicts with.
+	Existing string
+	// Pos is the byte offset in Path where the conflict starts. If a handler
+	// is already registered for the same path, it is the end of that path,
+	// which is the length of Path unless the path is expanded from optional
+	// parts.
+	Pos int
+
+	// conflicting wildcards of Path and Existing, empty for duplicates, and
+	// the prefix of the path in front of them
+	wildcard, existingWildcard, prefix string
+}
+
+func (e *ConflictError) Error() string {
+	if e.wildcard == "" {
+		if e.Existing != e.Path {
+			return "a handler is already registered for path '" + e.Path +
+				"' by route '" + e.Existing + "'"
+		}
+		return "a handler is already registered for path '" + e.Path + "'"
+	}
+	return "'" + e.wildcard +
+		"' in new path '" + e.Path +
+		"' conflicts with existing wildcard '" + e.existingWildcard +
+		"' in existing prefix '" + e.prefix + e.existingWildcard +
+		"'"
+}
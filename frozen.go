@@ -0,0 +1,32 @@
+package router
+
+// Freeze makes the route set of the router immutable, for route sets which do
+// not change after startup. Frozen routers match requests like other routers.
+//
+// Routes can not be registered, removed, mounted or named on a frozen router,
+// Register and the like panic and TryRegister returns ErrFrozen. Registered
+// routes can still be disabled and enabled. The routers of hosts are frozen
+// as well, mounted routers are not.
+func (r *Router) Freeze() {
+	var hosts []*Router
+	r.update(func(t *table) error {
+		t.frozen = true
+
+		for _, sub := range t.hosts {
+			hosts = append(hosts, sub)
+		}
+		for _, hp := range t.hostPatterns {
+			hosts = append(hosts, hp.router)
+		}
+		return nil
+	})
+
+	for _, sub := range hosts {
+		sub.Freeze()
+	}
+}
+
+// Frozen reports whether the router is frozen, see Freeze.
+func (r *Router) Frozen() bool {
+	return r.load().frozen
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterFreeze(t *testing.T) {
+	routed := ""
+	var params goa.Params
+	record := func(name string) Handler {
+		return func(c *goa.Context) { routed, params = name, c.Params }
+	}
+
+	router := New()
+	router.GET("/users", record("users"))
+	router.GET("/users/:id", record("user")).Name("user")
+	disabled := router.GET("/posts", record("posts"))
+	router.Host("admin.example.com").GET("/users", record("admin"))
+	sub := New()
+	sub.GET("/status", record("status"))
+	router.Mount("/api", sub)
+
+	router.Freeze()
+	if !router.Frozen() || !router.Host("admin.example.com").Frozen() {
+		t.Fatal("router or host router not frozen")
+	}
+	if sub.Frozen() {
+		t.Error("mounted router frozen")
+	}
+	disabled.Disable()
+
+	tests := []struct {
+		host, path string
+		route      string
+		params     goa.Params
+	}{
+		{"", "/users", "users", nil},
+		{"", "/users/42", "user", goa.Params{{Key: "id", Value: "42"}}},
+		{"", "/posts", "", nil},
+		{"", "/api/status", "status", nil},
+		{"admin.example.com", "/users", "admin", nil},
+	}
+	for _, test := range tests {
+		routed, params = "", nil
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.Host = test.host
+		handle(&goa.Context{}, req, router)
+		if routed != test.route || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s%s: routed to %q with %v, want %q with %v", test.host, test.path, routed, params, test.route, test.params)
+		}
+	}
+
+	h := func(c *goa.Context) {}
+	if _, err := router.TryRegister("GET", "/new", h); err != ErrFrozen {
+		t.Errorf("TryRegister on a frozen router returned %v, want ErrFrozen", err)
+	}
+	if router.Remove("GET", "/users") {
+		t.Error("route removed from a frozen router")
+	}
+	for name, fn := range map[string]func(){
+		"Register":   func() { router.GET("/new", h) },
+		"Name":       func() { disabled.Name("posts") },
+		"Mount":      func() { router.Mount("/new", New()) },
+		"Host":       func() { router.Host("new.example.com") },
+		"Replace":    func() { router.Replace(func(b *Builder) {}) },
+		"host route": func() { router.Host("admin.example.com").GET("/new", h) },
+	} {
+		if recv := catchPanic(fn); recv == nil {
+			t.Errorf("%s: no panic on a frozen router", name)
+		}
+	}
+
+	// freezing again is a no-op
+	router.Freeze()
+	if url, err := router.URL("user", "id", "1"); err != nil || url != "/users/1" {
+		t.Errorf("URL(\"user\") = %q, %v; want \"/users/1\"", url, err)
+	}
+}
+
+func BenchmarkFrozenStatic(b *testing.B) {
+	benchmarkHandle(b, "/search/repositories", true)
+}
+
+func BenchmarkFrozenParam(b *testing.B) {
+	benchmarkHandle(b, "/users/42", true)
+}
+
+func BenchmarkFrozenParams(b *testing.B) {
+	benchmarkHandle(b, "/repos/goa-go/router/issues/7", true)
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Group is a set of routes sharing a path prefix and a middleware chain.
+// Groups can be nested, the inner group inherits the prefix and the
+// middleware of the outer one.
+type Group struct {
+	router     *Router
+	prefix     string
+	middleware []Middleware
+}
+
+func newGroup(r *Router, prefix string, middleware []Middleware) *Group {
+	if len(prefix) == 0 || prefix[0] != '/' {
+		panic("prefix must begin with '/' in prefix '" + prefix + "'")
+	}
+
+	return &Group{
+		router:     r,
+		prefix:     strings.TrimSuffix(prefix, "/"),
+		middleware: middleware,
+	}
+}
+
+// Group returns a nested route group.
+// Its prefix is appended to the prefix of g and its middleware runs after the
+// middleware of g.
+func (g *Group) Group(prefix string, middleware ...Middleware) *Group {
+	if len(prefix) == 0 || prefix[0] != '/' {
+		panic("prefix must begin with '/' in prefix '" + prefix + "'")
+	}
+
+	return newGroup(g.router, g.prefix+prefix, g.chain(middleware))
+}
+
+// chain returns the group middleware followed by the given middleware.
+func (g *Group) chain(middleware []Middleware) []Middleware {
+	mw := make([]Middleware, 0, len(g.middleware)+len(middleware))
+	mw = append(mw, g.middleware...)
+	return append(mw, middleware...)
+}
+
+// GET registers a new request handle with the given path and get method.
+func (g *Group) GET(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("GET", path, handler, middleware...)
+}
+
+// HEAD registers a new request handle with the given path and head method.
+func (g *Group) HEAD(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("HEAD", path, handler, middleware...)
+}
+
+// OPTIONS registers a new request handle with the given path and options method.
+func (g *Group) OPTIONS(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("OPTIONS", path, handler, middleware...)
+}
+
+// POST registers a new request handle with the given path and post method.
+func (g *Group) POST(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("POST", path, handler, middleware...)
+}
+
+// PUT registers a new request handle with the given path and put method.
+func (g *Group) PUT(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("PUT", path, handler, middleware...)
+}
+
+// PATCH registers a new request handle with the given path and patch method.
+func (g *Group) PATCH(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("PATCH", path, handler, middleware...)
+}
+
+// DELETE registers a new request handle with the given path and delete method.
+func (g *Group) DELETE(path string, handler Handler, middleware ...Middleware) *Route {
+	return g.Register("DELETE", path, handler, middleware...)
+}
+
+// Register registers a new request handle with the group prefix prepended to
+// the given path. The group middleware runs before the route middleware.
+func (g *Group) Register(method, path string, handler Handler, middleware ...Middleware) *Route {
+	route, err := g.TryRegister(method, path, handler, middleware...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return route
+}
+
+// TryRegister is like Register but returns an error instead of panicking,
+// see Router.TryRegister.
+func (g *Group) TryRegister(method, path string, handler Handler, middleware ...Middleware) (*Route, error) {
+	if len(path) == 0 || path[0] != '/' {
+		return nil, &InvalidPatternError{Path: path, Reason: "path must begin with '/'"}
+	}
+
+	return g.router.TryRegister(method, g.prefix+path, handler, g.chain(middleware)...)
+}
+
+// ServeFiles serves files from the given file system root below the group
+// prefix. See Router.ServeFiles for details.
+func (g *Group) ServeFiles(path string, root http.FileSystem) {
+	handler, err := fileHandler(g.prefix+path, root)
+	if err != nil {
+		panic(err.Error())
+	}
+	g.GET(path, handler)
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestGroup(t *testing.T) {
+	calls := []string{}
+	logger := func(name string) Middleware {
+		return func(c *goa.Context, next func()) {
+			calls = append(calls, name+">")
+			next()
+			calls = append(calls, "<"+name)
+		}
+	}
+
+	router := New()
+	api := router.Group("/api", logger("api"))
+	v1 := api.Group("/v1/", logger("v1"))
+
+	var routed bool
+	v1.GET("/users/:id", func(c *goa.Context) {
+		routed = true
+		calls = append(calls, "handler")
+		if id := c.Param("id"); id != "42" {
+			t.Errorf("wrong param value: want 42, got %s", id)
+		}
+	}, logger("route"))
+
+	c := &goa.Context{}
+	r, _ := http.NewRequest("GET", "/api/v1/users/42", nil)
+	handle(c, r, router)
+	if !routed {
+		t.Fatal("routing group route failed")
+	}
+
+	want := []string{"api>", "v1>", "route>", "handler", "<route", "<v1", "<api"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("wrong middleware order: want %v, got %v", want, calls)
+	}
+}
+
+func TestGroupAPI(t *testing.T) {
+	var get, head, options, post, put, patch, delete, register bool
+
+	g := New().Group("/g")
+	g.GET("/GET", func(c *goa.Context) {
+		get = true
+	})
+	g.HEAD("/GET", func(c *goa.Context) {
+		head = true
+	})
+	g.OPTIONS("/GET", func(c *goa.Context) {
+		options = true
+	})
+	g.POST("/POST", func(c *goa.Context) {
+		post = true
+	})
+	g.PUT("/PUT", func(c *goa.Context) {
+		put = true
+	})
+	g.PATCH("/PATCH", func(c *goa.Context) {
+		patch = true
+	})
+	g.DELETE("/DELETE", func(c *goa.Context) {
+		delete = true
+	})
+	g.Register("GET", "/Register", func(c *goa.Context) {
+		register = true
+	})
+
+	c := &goa.Context{}
+	for _, req := range [...]struct {
+		method, path string
+		called       *bool
+	}{
+		{"GET", "/g/GET", &get},
+		{"HEAD", "/g/GET", &head},
+		{"OPTIONS", "/g/GET", &options},
+		{"POST", "/g/POST", &post},
+		{"PUT", "/g/PUT", &put},
+		{"PATCH", "/g/PATCH", &patch},
+		{"DELETE", "/g/DELETE", &delete},
+		{"GET", "/g/Register", &register},
+	} {
+		r, _ := http.NewRequest(req.method, req.path, nil)
+		handle(c, r, g.router)
+		if !*req.called {
+			t.Errorf("routing %s %s failed", req.method, req.path)
+		}
+	}
+}
+
+func TestGroupInvalidPath(t *testing.T) {
+	router := New()
+	if recv := catchPanic(func() {
+		router.Group("api")
+	}); recv == nil {
+		t.Error("creating group with prefix not beginning with '/' did not panic")
+	}
+
+	g := router.Group("/api")
+	if recv := catchPanic(func() {
+		g.GET("users", nil)
+	}); recv == nil {
+		t.Error("registering path not beginning with '/' did not panic")
+	}
+}
+
+func TestGroupServeFiles(t *testing.T) {
+	c := &goa.Context{}
+	router := New()
+	mfs := &mockFileSystem{}
+
+	var called bool
+	router.Group("/static", func(c *goa.Context, next func()) {
+		called = true
+		next()
+	}).ServeFiles("/*filepath", mfs)
+
+	r, _ := http.NewRequest("GET", "/static/favicon.ico", nil)
+	c.ResponseWriter = httptest.NewRecorder()
+	handle(c, r, router)
+	if !called {
+		t.Error("group middleware not called")
+	}
+	if !mfs.opened {
+		t.Error("serving file failed")
+	}
+}
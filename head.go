@@ -0,0 +1,67 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headWriter is the response writer of HEAD requests served by GET routes,
+// see Router.HandleHEADFromGET. It discards the body and sets the
+// Content-Length header to its length.
+//
+// The status is sent when the handler returned, since the length of the body
+// is unknown until then. If the handler did not write a response, goa writes
+// it afterwards with a single write, which sends the status.
+type headWriter struct {
+	http.ResponseWriter
+
+	status  int
+	length  int
+	done    bool
+	flushed bool
+}
+
+func (w *headWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.length += len(p)
+	if w.done {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+// handlerDone is called when the handler returned or panicked.
+func (w *headWriter) handlerDone() {
+	w.done = true
+	if w.status != 0 {
+		w.flush()
+	}
+}
+
+// flush sends the status with the Content-Length header.
+func (w *headWriter) flush() {
+	if w.flushed {
+		return
+	}
+	w.flushed = true
+
+	header := w.Header()
+	if header.Get("Content-Length") == "" && bodyAllowed(w.status) {
+		header.Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// bodyAllowed reports whether a response with the given status may have a
+// body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"strings"
+
+	"github.com/goa-go/goa"
+)
+
+// hostPattern is a host pattern with wildcard labels, see Router.Host.
+type hostPattern struct {
+	// labels of the pattern, '*' and ':name' labels are wildcards
+	labels []string
+	router *Router
+}
+
+// Host returns the router for requests to the given host. Routes registered
+// on it are only matched for that host, requests to hosts without a router of
+// their own are routed by r.
+//
+// The pattern is either an exact host name, or contains wildcard labels:
+// A leading '*' label matches one or more labels, a ':name' label matches
+// exactly one label, which is prepended to c.Params under the given name.
+// Exact hosts take priority over patterns, which are tried in the order they
+// were added. The port of the request host is ignored.
+//
+// api := router.Host("api.example.com")
+// tenants := router.Host(":tenant.example.com")
+// any := router.Host("*.example.com")
+//
+// The host router is configured like r when it is created, later changes of
+// r's options are not applied to it. Host panics if the pattern is malformed,
+// or if r is frozen and has no router for the pattern yet.
+func (r *Router) Host(pattern string) *Router {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	labels := strings.Split(pattern, ".")
+
+	wildcard := false
+	for i, label := range labels {
+		switch {
+		case label == "":
+			panic("empty label in host pattern '" + pattern + "'")
+		case label == "*":
+			if i > 0 {
+				panic("'*' must be the first label in host pattern '" + pattern + "'")
+			}
+			wildcard = true
+		case label[0] == ':':
+			if len(label) < 2 {
+				panic("wildcards must be named with a non-empty name in host pattern '" + pattern + "'")
+			}
+			wildcard = true
+		case strings.ContainsAny(label, ":*"):
+			panic("only one wildcard per label is allowed in host pattern '" + pattern + "'")
+		}
+	}
+
+	// the router of a frozen table can still be looked up
+	if sub := r.load().host(pattern); sub != nil {
+		return sub
+	}
+
+	var sub *Router
+	err := r.update(func(t *table) error {
+		if sub = t.host(pattern); sub != nil {
+			return nil
+		}
+
+		sub = r.hostRouter()
+		if wildcard {
+			t.hostPatterns = append(t.hostPatterns, &hostPattern{labels: labels, router: sub})
+		} else {
+			// the map is shared with the published table
+			hosts := make(map[string]*Router, len(t.hosts)+1)
+			for host, router := range t.hosts {
+				hosts[host] = router
+			}
+			hosts[pattern] = sub
+			t.hosts = hosts
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+	return sub
+}
+
+// host returns the router of the host pattern, or nil if it has none.
+func (t *table) host(pattern string) *Router {
+	if sub := t.hosts[pattern]; sub != nil {
+		return sub
+	}
+	for _, hp := range t.hostPatterns {
+		if strings.Join(hp.labels, ".") == pattern {
+			return hp.router
+		}
+	}
+	return nil
+}
+
+// hostRouter returns a new router with the options of r.
+func (r *Router) hostRouter() *Router {
+	return &Router{
+		RedirectTrailingSlash:  r.RedirectTrailingSlash,
+		RedirectPolicy:         r.RedirectPolicy,
+		CaseInsensitive:        r.CaseInsensitive,
+		UseRawPath:             r.UseRawPath,
+		UnescapePathValues:     r.UnescapePathValues,
+		RedirectFixedPath:      r.RedirectFixedPath,
+		HandleMethodNotAllowed: r.HandleMethodNotAllowed,
+		HandleHEADFromGET:      r.HandleHEADFromGET,
+		HandleOPTIONS:          r.HandleOPTIONS,
+		NotFound:               r.NotFound,
+		MethodNotAllowed:       r.MethodNotAllowed,
+		SaveMatchedRoute:       r.SaveMatchedRoute,
+	}
+}
+
+// matchHost returns the router for the given request host and the params
+// captured from it, or nil if the host has no router of its own.
+func (t *table) matchHost(host string) (*Router, goa.Params) {
+	host = strings.ToLower(strings.TrimSuffix(stripPort(host), "."))
+
+	if sub := t.hosts[host]; sub != nil {
+		return sub, nil
+	}
+
+	labels := strings.Split(host, ".")
+	for _, hp := range t.hostPatterns {
+		if ps, ok := hp.match(labels); ok {
+			return hp.router, ps
+		}
+	}
+	return nil, nil
+}
+
+// match matches the labels of a host against the pattern.
+func (hp *hostPattern) match(labels []string) (goa.Params, bool) {
+	pattern := hp.labels
+	if pattern[0] == "*" {
+		// '*' matches at least one label
+		if len(labels) < len(pattern) {
+			return nil, false
+		}
+		labels = labels[len(labels)-len(pattern)+1:]
+		pattern = pattern[1:]
+	} else if len(labels) != len(pattern) {
+		return nil, false
+	}
+
+	var ps goa.Params
+	for i, label := range pattern {
+		if label[0] == ':' {
+			if labels[i] == "" {
+				return nil, false
+			}
+			ps = append(ps, goa.Param{Key: label[1:], Value: labels[i]})
+		} else if label != labels[i] {
+			return nil, false
+		}
+	}
+	return ps, true
+}
+
+// stripPort removes the port from a host, if any.
+func stripPort(host string) string {
+	i := strings.LastIndexByte(host, ':')
+	if i < 0 || strings.IndexByte(host[i:], ']') >= 0 {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host[:i], "["), "]")
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterHost(t *testing.T) {
+	var routed string
+	var params goa.Params
+	route := func(name string) Handler {
+		return func(c *goa.Context) {
+			routed = name
+			params = c.Params
+		}
+	}
+
+	router := New()
+	router.GET("/users/:id", route("default"))
+	router.Host("api.example.com").GET("/users/:id", route("api"))
+	router.Host(":tenant.example.com").GET("/users/:id", route("tenant"))
+	router.Host("*.example.org").GET("/users/:id", route("org"))
+
+	if router.Host("API.example.com.") != router.Host("api.example.com") {
+		t.Error("Host returned a new router for a known host")
+	}
+
+	tests := []struct {
+		host   string
+		route  string
+		params goa.Params
+	}{
+		{"api.example.com", "api", goa.Params{{Key: "id", Value: "1"}}},
+		{"API.Example.com:8080", "api", goa.Params{{Key: "id", Value: "1"}}},
+		{"acme.example.com", "tenant", goa.Params{{Key: "tenant", Value: "acme"}, {Key: "id", Value: "1"}}},
+		{"a.b.example.org", "org", goa.Params{{Key: "id", Value: "1"}}},
+		{"example.org", "default", goa.Params{{Key: "id", Value: "1"}}},
+		{"a.b.example.com", "default", goa.Params{{Key: "id", Value: "1"}}},
+		{"localhost", "default", goa.Params{{Key: "id", Value: "1"}}},
+		{"[::1]:8080", "default", goa.Params{{Key: "id", Value: "1"}}},
+	}
+	for _, test := range tests {
+		routed, params = "", nil
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.Host = test.host
+		handle(&goa.Context{}, req, router)
+
+		if routed != test.route {
+			t.Errorf("host %s: routed to %q, want %q", test.host, routed, test.route)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("host %s: wrong params %v, want %v", test.host, params, test.params)
+		}
+	}
+}
+
+func TestRouterHostNotFound(t *testing.T) {
+	routed, notFound := false, false
+	router := New()
+	router.GET("/path", func(c *goa.Context) { routed = true })
+	router.NotFound = func(c *goa.Context) { notFound = true }
+	// the host router inherits the NotFound handler
+	router.Host("api.example.com").GET("/api", func(c *goa.Context) {})
+
+	// a host router does not fall back to the default routes
+	req, _ := http.NewRequest("GET", "/path", nil)
+	req.Host = "api.example.com"
+	handle(&goa.Context{}, req, router)
+	if routed {
+		t.Error("host router fell back to the default routes")
+	}
+	if !notFound {
+		t.Error("NotFound handler was not called")
+	}
+}
+
+func TestRouterHostInvalid(t *testing.T) {
+	router := New()
+	for _, pattern := range []string{
+		"",
+		"api..example.com",
+		"api.*.example.com",
+		":.example.com",
+		"a:b.example.com",
+	} {
+		if recv := catchPanic(func() { router.Host(pattern) }); recv == nil {
+			t.Errorf("no panic for invalid host pattern %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/goa-go/goa"
+)
+
+// ServeHTTP makes the router implement the http.Handler interface, so that it
+// can be used without setting up a goa app:
+//
+// http.ListenAndServe(":8080", router)
+//
+// Requests are served by a goa app which only uses the router's middleware,
+// it is created on the first request. Responses set on the context, e.g. with
+// c.JSON, are written after the handler returned as usual.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.appOnce.Do(func() {
+		r.app = goa.New()
+		r.app.Use(r.Routes())
+	})
+	r.app.ServeHTTP(w, req)
+}
+
+// paramsKey is the request context key of the params, see ParamsFromContext.
+type paramsKey struct{}
+
+// ParamsFromContext returns the params of the request handled by a
+// http.Handler registered with FromHTTP or FromHTTPFunc, or mounted with
+// MountHandler. The params are a copy, which remains valid after the handler
+// returned.
+//
+// id := router.ParamsFromContext(r.Context()).Get("id")
+func ParamsFromContext(ctx context.Context) goa.Params {
+	ps, _ := ctx.Value(paramsKey{}).(goa.Params)
+	return ps
+}
+
+// FromHTTP returns a Handler serving requests with h. The params are added
+// to the context of the request passed to h, see ParamsFromContext.
+func FromHTTP(h http.Handler) Handler {
+	return func(c *goa.Context) {
+		h.ServeHTTP(c.ResponseWriter, httpRequest(c, c.Request.URL.Path))
+		c.Handled = true
+	}
+}
+
+// FromHTTPFunc is like FromHTTP, but takes a handler function.
+func FromHTTPFunc(f func(http.ResponseWriter, *http.Request)) Handler {
+	return FromHTTP(http.HandlerFunc(f))
+}
+
+// httpRequest returns the request of the context with the given URL path and
+// a copy of the params added to its context. The params of the context are
+// reused for the next request once the handler returned, whereas the context
+// of the request may be used by work outliving the handler.
+func httpRequest(c *goa.Context, path string) *http.Request {
+	ps := append(goa.Params(nil), c.Params...)
+	req := c.Request.WithContext(context.WithValue(c.Request.Context(), paramsKey{}, ps))
+	if path != req.URL.Path {
+		u := *req.URL
+		u.Path = path
+		u.RawPath = ""
+		req.URL = &u
+	}
+	return req
+}
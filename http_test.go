@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterServeHTTP(t *testing.T) {
+	router := New()
+	router.GET("/users/:id", func(c *goa.Context) {
+		c.JSON(map[string]string{"id": c.Param("id")})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/users/42", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"id\":\"42\"}\n" {
+		t.Errorf("wrong response: Code=%d, Body=%q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/posts", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("wrong status for unknown path: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/users/42", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, OPTIONS" {
+		t.Errorf("wrong response for wrong method: Code=%d, Header=%v", w.Code, w.Header())
+	}
+}
+
+func TestFromHTTP(t *testing.T) {
+	var params goa.Params
+	h := func(w http.ResponseWriter, r *http.Request) {
+		params = ParamsFromContext(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	router := New()
+	router.GET("/users/:id", FromHTTPFunc(h))
+	router.MountHandler("/files/:bucket", http.HandlerFunc(h))
+
+	tests := []struct {
+		url    string
+		params goa.Params
+	}{
+		{"/users/42", goa.Params{{Key: "id", Value: "42"}}},
+		{"/files/docs/a.txt", goa.Params{{Key: "bucket", Value: "docs"}}},
+	}
+	for _, test := range tests {
+		params = nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != http.StatusTeapot {
+			t.Errorf("%s: handler was not called, Code=%d", test.url, w.Code)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: wrong params %v, want %v", test.url, params, test.params)
+		}
+	}
+
+	// the params in the context outlive the handler, e.g. for background work
+	var ctxs []context.Context
+	router.GET("/posts/:id", FromHTTPFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxs = append(ctxs, r.Context())
+	}))
+	for _, url := range []string{"/posts/1", "/posts/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	for i, ctx := range ctxs {
+		want := goa.Params{{Key: "id", Value: strconv.Itoa(i + 1)}}
+		if ps := ParamsFromContext(ctx); !reflect.DeepEqual(ps, want) {
+			t.Errorf("params of request %d changed to %v after the handler returned, want %v", i+1, ps, want)
+		}
+	}
+
+	if ps := ParamsFromContext(httptest.NewRequest("GET", "/", nil).Context()); ps != nil {
+		t.Errorf("params of a request without params: %v", ps)
+	}
+}
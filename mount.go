@@ -0,0 +1,144 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/goa-go/goa"
+)
+
+// mountPathParam is the name of the catch-all param holding the path below
+// a mount prefix.
+const mountPathParam = "mountpath"
+
+// mount is a sub-router or a http.Handler mounted under a prefix.
+type mount struct {
+	prefix  string
+	sub     *Router
+	handler http.Handler
+}
+
+// mountScopeKey is the context key the mountScope of a request is saved under
+// while a mounted router handles it.
+const mountScopeKey = "router.mountScope"
+
+// mountScope describes the mounts a request was passed through.
+type mountScope struct {
+	// the mount prefixes, e.g. /tenants/:tenant/api
+	prefix string
+	// whether a router passing the request on saves the matched route
+	saveRoute bool
+}
+
+// Mount routes all requests below prefix to the sub-router, regardless of
+// their method. The prefix is stripped from c.Path before sub routes the
+// request, params captured by the prefix are kept in c.Params.
+//
+// api := router.New()
+// api.GET("/users", listUsers)
+// router.Mount("/tenants/:tenant/api", api) // GET /tenants/acme/api/users
+//
+// Routes registered on r take priority over mounts. If the sub-router has no
+// route for the path, it answers OPTIONS and 405 requests for the path itself,
+// other requests are passed to its NotFound handler or, if it has none, to
+// r's NotFound handler.
+// Mount panics if the prefix is malformed or already mounted.
+func (r *Router) Mount(prefix string, sub *Router) {
+	r.mount(prefix, &mount{sub: sub})
+}
+
+// MountHandler routes all requests below prefix to the http.Handler, e.g.
+// net/http/pprof or a metrics handler, regardless of their method. The prefix
+// is stripped from the URL path of the request passed to h, params captured
+// by the prefix are kept in c.Params and added to the request context, see
+// ParamsFromContext.
+//
+// router.MountHandler("/metrics", promhttp.Handler())
+//
+// Routes registered on r take priority over mounts.
+// MountHandler panics if the prefix is malformed or already mounted.
+func (r *Router) MountHandler(prefix string, h http.Handler) {
+	r.mount(prefix, &mount{handler: h})
+}
+
+func (r *Router) mount(prefix string, m *mount) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	m.prefix = prefix
+	paths := []string{prefix, prefix + "/*" + mountPathParam}
+	if prefix == "" {
+		// the root prefix
+		paths = paths[1:]
+	} else if strings.IndexByte(prefix, '*') >= 0 {
+		panic("catch-all routes are not allowed in mount prefix '" + prefix + "'")
+	}
+
+	// the route is only used to find the router the mount belongs to, it is
+	// not listed
+	route := &Route{router: r, path: prefix}
+	handler := func(c *goa.Context) {
+		m.handle(c, route.router)
+	}
+
+	err := r.update(func(t *table) error {
+		root := t.mounts
+		if root == nil {
+			root = new(node)
+		}
+		for _, path := range paths {
+			var leaf *node
+			var err error
+			if root, leaf, err = root.insertRoute(path, handler); err != nil {
+				return err
+			}
+			leaf.route = route
+		}
+		t.mounts = root
+		return nil
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// handle serves a request routed to the mount of router r, the last param is
+// the path below the prefix unless the prefix itself was requested.
+func (m *mount) handle(c *goa.Context, r *Router) {
+	rest := "/"
+	if n := len(c.Params); n > 0 && c.Params[n-1].Key == mountPathParam {
+		rest = c.Params[n-1].Value
+		c.Params = c.Params[:n-1]
+	}
+
+	if m.handler != nil {
+		m.handler.ServeHTTP(c.ResponseWriter, httpRequest(c, rest))
+		c.Handled = true
+		return
+	}
+
+	// the route matched by sub is saved with the prefixes of all mounts
+	outer, nested := c.Get(mountScopeKey)
+	scope := mountScope{prefix: m.prefix, saveRoute: r.SaveMatchedRoute}
+	if nested {
+		scope.prefix = outer.(mountScope).prefix + scope.prefix
+		scope.saveRoute = scope.saveRoute || outer.(mountScope).saveRoute
+	}
+	c.Set(mountScopeKey, scope)
+
+	path := c.Path
+	c.Path = rest
+	handled := m.sub.handle(c, c.Params) || m.sub.handleAllowed(c)
+	if !handled && m.sub.NotFound != nil {
+		m.sub.NotFound(c)
+		handled = true
+	}
+	c.Path = path
+	if nested {
+		c.Set(mountScopeKey, outer)
+	} else {
+		delete(c.Keys, mountScopeKey)
+	}
+
+	if !handled {
+		r.unhandled(c)
+	}
+}
@@ -0,0 +1,192 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterMount(t *testing.T) {
+	var routed string
+	var params goa.Params
+	var path string
+	route := func(name string) Handler {
+		return func(c *goa.Context) {
+			routed, params, path = name, c.Params, c.Path
+		}
+	}
+
+	sub := New()
+	sub.GET("/", route("sub index"))
+	sub.GET("/users/:id", route("sub user"))
+
+	router := New()
+	router.GET("/tenants/:tenant/api/native", route("native"))
+	router.Mount("/tenants/:tenant/api", sub)
+
+	tests := []struct {
+		method string
+		url    string
+		route  string
+		params goa.Params
+		path   string
+	}{
+		{"GET", "/tenants/acme/api/users/1", "sub user",
+			goa.Params{{Key: "tenant", Value: "acme"}, {Key: "id", Value: "1"}}, "/users/1"},
+		{"GET", "/tenants/acme/api", "sub index", goa.Params{{Key: "tenant", Value: "acme"}}, "/"},
+		{"GET", "/tenants/acme/api/", "sub index", goa.Params{{Key: "tenant", Value: "acme"}}, "/"},
+		{"GET", "/tenants/acme/api/native", "native", goa.Params{{Key: "tenant", Value: "acme"}}, "/tenants/acme/api/native"},
+	}
+	for _, test := range tests {
+		routed, params, path = "", nil, ""
+		req, _ := http.NewRequest(test.method, test.url, nil)
+		c := &goa.Context{}
+		handle(c, req, router)
+
+		if routed != test.route {
+			t.Errorf("%s %s: routed to %q, want %q", test.method, test.url, routed, test.route)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s %s: wrong params %v, want %v", test.method, test.url, params, test.params)
+		}
+		if path != test.path {
+			t.Errorf("%s %s: handler saw path %q, want %q", test.method, test.url, path, test.path)
+		}
+		if c.Path != req.URL.Path {
+			t.Errorf("%s %s: path not restored, got %q", test.method, test.url, c.Path)
+		}
+	}
+}
+
+func TestRouterMountMatchedRoute(t *testing.T) {
+	var matched string
+	record := func(c *goa.Context) { matched = MatchedRoute(c) }
+
+	users := New()
+	users.GET("/:id", record)
+	sub := New()
+	sub.GET("/users/:id", record)
+	sub.Mount("/teams/:team/users", users)
+
+	tests := []struct {
+		url           string
+		parent, child bool
+		route         string
+	}{
+		{"/tenants/acme/api/users/1", true, false, "/tenants/:tenant/api/users/:id"},
+		{"/tenants/acme/api/users/1", false, true, "/tenants/:tenant/api/users/:id"},
+		{"/tenants/acme/api/users/1", false, false, ""},
+		{"/tenants/acme/api/teams/go/users/1", true, false, "/tenants/:tenant/api/teams/:team/users/:id"},
+	}
+	for _, test := range tests {
+		matched = ""
+		router := New()
+		router.SaveMatchedRoute = test.parent
+		sub.SaveMatchedRoute = test.child
+		router.Mount("/tenants/:tenant/api", sub)
+
+		req, _ := http.NewRequest("GET", test.url, nil)
+		c := &goa.Context{}
+		handle(c, req, router)
+		if matched != test.route {
+			t.Errorf("%s (parent %v, child %v): matched route %q, want %q",
+				test.url, test.parent, test.child, matched, test.route)
+		}
+		if _, ok := c.Get(mountScopeKey); ok {
+			t.Errorf("%s: mount scope left in the context", test.url)
+		}
+	}
+}
+
+func TestRouterMountNotFound(t *testing.T) {
+	sub := New()
+	sub.GET("/users", func(c *goa.Context) {})
+
+	notFound := ""
+	router := New()
+	router.NotFound = func(c *goa.Context) { notFound = c.Path }
+	router.Mount("/api", sub)
+
+	// the parent's NotFound handler sees the full path
+	req, _ := http.NewRequest("GET", "/api/posts", nil)
+	handle(&goa.Context{}, req, router)
+	if notFound != "/api/posts" {
+		t.Errorf("NotFound handler of the parent was not called, got %q", notFound)
+	}
+
+	// the sub-router answers OPTIONS requests
+	w := httptest.NewRecorder()
+	req, _ = http.NewRequest("OPTIONS", "/api/users", nil)
+	handle(&goa.Context{ResponseWriter: w}, req, router)
+	if allow := w.Header().Get("Allow"); allow != "GET, OPTIONS" {
+		t.Errorf("wrong Allow header for OPTIONS request: %q", allow)
+	}
+
+	// the sub-router redirects below the prefix
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/users/", nil)
+	handle(&goa.Context{ResponseWriter: w}, req, router)
+	if loc := w.Header().Get("Location"); w.Code != 301 || loc != "/api/users" {
+		t.Errorf("wrong redirect: Code=%d, Location=%q", w.Code, loc)
+	}
+
+	// the NotFound handler of the sub-router takes priority
+	notFound = ""
+	subNotFound := false
+	sub.NotFound = func(c *goa.Context) { subNotFound = true }
+	req, _ = http.NewRequest("GET", "/api/posts", nil)
+	handle(&goa.Context{}, req, router)
+	if !subNotFound || notFound != "" {
+		t.Error("NotFound handler of the sub-router was not called")
+	}
+}
+
+func TestRouterMountHandler(t *testing.T) {
+	var path string
+	var params goa.Params
+	router := New()
+	router.MountHandler("/debug/:name", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	router.GET("/debug/:name/native", func(c *goa.Context) { params = c.Params })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/debug/pprof/profile?seconds=1", nil)
+	c := &goa.Context{ResponseWriter: w}
+	handle(c, req, router)
+	if w.Code != http.StatusTeapot || path != "/profile" {
+		t.Errorf("mounted handler was not called correctly: Code=%d, path=%q", w.Code, path)
+	}
+	if !c.Handled {
+		t.Error("request not marked as handled")
+	}
+	if want := (goa.Params{{Key: "name", Value: "pprof"}}); !reflect.DeepEqual(c.Params, want) {
+		t.Errorf("wrong params %v, want %v", c.Params, want)
+	}
+	if req.URL.Path != "/debug/pprof/profile" {
+		t.Errorf("request URL was modified: %q", req.URL.Path)
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/pprof/native", nil)
+	handle(&goa.Context{}, req, router)
+	if params == nil {
+		t.Error("native route did not take priority over the mount")
+	}
+
+	recv := catchPanic(func() {
+		router.MountHandler("/debug/:name/", http.NotFoundHandler())
+	})
+	if recv == nil {
+		t.Error("mounting twice at the same prefix did not panic")
+	}
+	recv = catchPanic(func() {
+		router.MountHandler("/files/*filepath", http.NotFoundHandler())
+	})
+	if recv == nil {
+		t.Error("mounting at a catch-all prefix did not panic")
+	}
+}
@@ -0,0 +1,304 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goa-go/goa"
+)
+
+// OpenAPIInfo is the info object of a generated OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Schema is a JSON schema as used by OpenAPI, e.g.
+// Schema{"type": "object", "properties": Schema{"name": Schema{"type": "string"}}}
+type Schema map[string]interface{}
+
+// Operation documents a route in the generated OpenAPI document, see
+// Route.Doc.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+
+	// Hidden excludes the route from the document.
+	Hidden bool
+
+	// Params documents path params by name. The schema of a param which is
+	// not documented here is derived from its constraint.
+	Params map[string]Schema
+
+	// Request is the schema of the JSON request body, nil if the route takes
+	// no body.
+	Request Schema
+
+	// Responses maps status codes to responses. A route without responses
+	// is documented with a default response.
+	Responses map[int]Response
+}
+
+// Response documents a response of an Operation.
+type Response struct {
+	Description string
+	// Schema is the schema of the JSON response body, nil if the response
+	// has no body.
+	Schema Schema
+}
+
+// Doc attaches OpenAPI metadata to the route, see Router.OpenAPIJSON.
+func (rt *Route) Doc(op Operation) *Route {
+	rt.router.mu.Lock()
+	rt.doc = &op
+	rt.router.mu.Unlock()
+	return rt
+}
+
+// openAPIMethods are the methods an OpenAPI path item can describe.
+var openAPIMethods = map[string]string{
+	"GET":     "get",
+	"PUT":     "put",
+	"POST":    "post",
+	"DELETE":  "delete",
+	"OPTIONS": "options",
+	"HEAD":    "head",
+	"PATCH":   "patch",
+	"TRACE":   "trace",
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Paths   map[string]map[string]*openAPIOperation `json:"paths"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   Schema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema Schema `json:"schema"`
+}
+
+func jsonContent(schema Schema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// openAPI builds the OpenAPI 3 document of the registered routes.
+func (r *Router) openAPI(info OpenAPIInfo) *openAPIDocument {
+	// route metadata is modified while holding the lock
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+
+	for method, root := range r.load().trees {
+		m, ok := openAPIMethods[method]
+		if !ok {
+			continue
+		}
+
+		root.walk(func(leaf *node) {
+			route := leaf.route
+			if route.doc != nil && route.doc.Hidden {
+				return
+			}
+
+			path := openAPIPath(leaf.fullPath)
+			item := doc.Paths[path]
+			if item == nil {
+				item = make(map[string]*openAPIOperation)
+				doc.Paths[path] = item
+			}
+			// routes only differing in their constraints share a path,
+			// document the first one
+			if item[m] == nil {
+				item[m] = route.openAPIOperation(leaf.fullPath)
+			}
+		})
+	}
+
+	return doc
+}
+
+// openAPIOperation returns the operation of the route for one of its paths,
+// see expandOptional.
+func (rt *Route) openAPIOperation(path string) *openAPIOperation {
+	op := &openAPIOperation{Responses: make(map[string]*openAPIResponse)}
+
+	// operation IDs must be unique, the paths of optional parts are
+	// documented without one
+	if paths, _ := expandOptional(rt.path); path == paths[0] {
+		op.OperationID = rt.name
+	}
+
+	doc := rt.doc
+	if doc == nil {
+		doc = &Operation{}
+	}
+	op.Summary = doc.Summary
+	op.Description = doc.Description
+	op.Tags = doc.Tags
+	op.Deprecated = doc.Deprecated
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != ':' && path[i] != '*' {
+			continue
+		}
+		end := wildcardEnd(path, i)
+		name, pattern := splitWildcard(path[i:end])
+		i = end - 1
+
+		schema := doc.Params[name]
+		if schema == nil {
+			schema = constraintSchema(pattern)
+		}
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   schema,
+		})
+	}
+
+	if doc.Request != nil {
+		op.RequestBody = &openAPIBody{Required: true, Content: jsonContent(doc.Request)}
+	}
+
+	for code, resp := range doc.Responses {
+		response := &openAPIResponse{Description: resp.Description}
+		if response.Description == "" {
+			response.Description = statusText(code)
+		}
+		if resp.Schema != nil {
+			response.Content = jsonContent(resp.Schema)
+		}
+		op.Responses[strconv.Itoa(code)] = response
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["default"] = &openAPIResponse{Description: "Default response"}
+	}
+
+	return op
+}
+
+// openAPIPath converts a route path to an OpenAPI path template, e.g.
+// /users/:id<int>/*filepath to /users/{id}/{filepath}.
+func openAPIPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != ':' && path[i] != '*' {
+			b.WriteByte(path[i])
+			continue
+		}
+		end := wildcardEnd(path, i)
+		name, _ := splitWildcard(path[i:end])
+		b.WriteString("{" + name + "}")
+		i = end - 1
+	}
+	return b.String()
+}
+
+// constraintSchema returns the schema of a param with the given constraint
+// pattern.
+func constraintSchema(pattern string) Schema {
+	switch pattern {
+	case "":
+		return Schema{"type": "string"}
+	case "int":
+		return Schema{"type": "integer"}
+	case "uuid":
+		return Schema{"type": "string", "format": "uuid"}
+	case "alpha":
+		return Schema{"type": "string", "pattern": "^[A-Za-z]+$"}
+	case "date":
+		return Schema{"type": "string", "format": "date"}
+	default:
+		return Schema{"type": "string", "pattern": "^(?:" + pattern + ")$"}
+	}
+}
+
+func statusText(code int) string {
+	if text := http.StatusText(code); text != "" {
+		return text
+	}
+	return "Response " + strconv.Itoa(code)
+}
+
+// OpenAPIJSON returns an OpenAPI 3 document of the registered routes as
+// JSON. Wildcards become path params, their schema is derived from their
+// constraints. Further metadata is taken from Route.Doc.
+func (r *Router) OpenAPIJSON(info OpenAPIInfo) ([]byte, error) {
+	return json.MarshalIndent(r.openAPI(info), "", "  ")
+}
+
+// OpenAPIYAML is like OpenAPIJSON but returns the document as YAML.
+func (r *Router) OpenAPIYAML(info OpenAPIInfo) ([]byte, error) {
+	data, err := json.Marshal(r.openAPI(info))
+	if err != nil {
+		return nil, err
+	}
+	return jsonToYAML(data)
+}
+
+// ServeOpenAPI registers a GET route at path serving the OpenAPI document of
+// the router. The document is served as YAML if path ends with .yaml or
+// .yml, as JSON otherwise. It is generated per request, so it includes routes
+// registered later on. The route itself is hidden from the document.
+//
+// router.ServeOpenAPI("/openapi.json", router.OpenAPIInfo{Title: "API", Version: "1.0"})
+func (r *Router) ServeOpenAPI(path string, info OpenAPIInfo) *Route {
+	yaml := strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")
+
+	return r.GET(path, func(c *goa.Context) {
+		var (
+			data []byte
+			err  error
+		)
+		if yaml {
+			c.SetHeader("Content-Type", "application/yaml; charset=utf-8")
+			data, err = r.OpenAPIYAML(info)
+		} else {
+			c.SetHeader("Content-Type", "application/json; charset=utf-8")
+			data, err = r.OpenAPIJSON(info)
+		}
+		if err != nil {
+			panic(err)
+		}
+		c.String(string(data))
+	}).Doc(Operation{Hidden: true})
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterOpenAPI(t *testing.T) {
+	h := func(c *goa.Context) {}
+
+	router := New()
+	router.GET("/users", h).Name("listUsers").Doc(Operation{
+		Summary: "List users",
+		Tags:    []string{"users"},
+		Responses: map[int]Response{
+			200: {Schema: Schema{"type": "array", "items": Schema{"type": "object"}}},
+		},
+	})
+	router.POST("/users", h).Doc(Operation{
+		Request:   Schema{"type": "object"},
+		Responses: map[int]Response{201: {Description: "Created"}},
+	})
+	router.GET("/users/:id<int>", h)
+	router.GET("/src/*filepath", h)
+	router.GET("/internal", h).Doc(Operation{Hidden: true})
+	router.Register("PROPFIND", "/dav", h)
+
+	data, err := router.OpenAPIJSON(OpenAPIInfo{Title: "Test", Version: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("wrong openapi version: %v", doc["openapi"])
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	var got []string
+	for path := range paths {
+		got = append(got, path)
+	}
+	want := []string{"/src/{filepath}", "/users", "/users/{id}"}
+	if len(got) != len(want) {
+		t.Fatalf("wrong paths: %v, want %v", got, want)
+	}
+	for _, path := range want {
+		if paths[path] == nil {
+			t.Errorf("missing path %s", path)
+		}
+	}
+
+	list := paths["/users"].(map[string]interface{})["get"].(map[string]interface{})
+	if list["operationId"] != "listUsers" || list["summary"] != "List users" {
+		t.Errorf("wrong metadata: %v", list)
+	}
+	ok := list["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	if ok["description"] != "OK" || ok["content"] == nil {
+		t.Errorf("wrong response: %v", ok)
+	}
+
+	create := paths["/users"].(map[string]interface{})["post"].(map[string]interface{})
+	if create["requestBody"] == nil {
+		t.Error("missing request body")
+	}
+
+	user := paths["/users/{id}"].(map[string]interface{})["get"].(map[string]interface{})
+	params := user["parameters"].([]interface{})
+	wantParam := map[string]interface{}{
+		"name":     "id",
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "integer"},
+	}
+	if len(params) != 1 || !reflect.DeepEqual(params[0], wantParam) {
+		t.Errorf("wrong parameters: %v", params)
+	}
+	if _, ok := user["responses"].(map[string]interface{})["default"]; !ok {
+		t.Error("missing default response")
+	}
+}
+
+func TestRouterServeOpenAPI(t *testing.T) {
+	router := New()
+	router.GET("/users/:id", func(c *goa.Context) {}).Doc(Operation{Summary: "Get: a user"})
+	router.ServeOpenAPI("/openapi.json", OpenAPIInfo{Title: "Test", Version: "1.0"})
+	router.ServeOpenAPI("/openapi.yaml", OpenAPIInfo{Title: "Test", Version: "1.0"})
+
+	app := goa.New()
+	app.Use(router.Routes())
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("wrong status: %d", w.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if paths := doc["paths"].(map[string]interface{}); len(paths) != 1 {
+		t.Errorf("spec routes are not hidden: %v", paths)
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.yaml", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/yaml") {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	for _, line := range []string{
+		"openapi: \"3.0.3\"",
+		"info:\n  title: Test\n  version: \"1.0\"",
+		"  /users/{id}:\n    get:",
+		"        - name: id\n          in: path\n          required: true",
+		"      summary: \"Get: a user\"",
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("YAML document does not contain %q:\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"users", "users"},
+		{"/users/{id}", "/users/{id}"},
+		{"List users", "List users"},
+		{"", `""`},
+		{" x", `" x"`},
+		{"true", `"true"`},
+		{"Off", `"Off"`},
+		{"y", `"y"`},
+		{"~", `"~"`},
+		{"null", `"null"`},
+		{"<<", `"<<"`},
+		{"a&b", "a&b"},
+		{"@a&<b>", `"@a&<b>"`},
+		{"42", `"42"`},
+		{"1.0", `"1.0"`},
+		{"1e3", `"1e3"`},
+		{"+12", `"+12"`},
+		{"-12", `"-12"`},
+		{"0x1F", `"0x1F"`},
+		{"0o17", `"0o17"`},
+		{"0b101", `"0b101"`},
+		{"1_000", `"1_000"`},
+		{"190:20:30", `"190:20:30"`},
+		{".inf", `".inf"`},
+		{"-.Inf", `"-.Inf"`},
+		{".NaN", `".NaN"`},
+		{"2019-01-01", `"2019-01-01"`},
+		{"2001-12-14t21:59:43.10-05:00", `"2001-12-14t21:59:43.10-05:00"`},
+		{"a: b", `"a: b"`},
+		{"#x", `"#x"`},
+		{"a\nb", `"a\nb"`},
+	}
+	for _, test := range tests {
+		if out := yamlString(test.in); out != test.out {
+			t.Errorf("yamlString(%q) = %s, want %s", test.in, out, test.out)
+		}
+	}
+}
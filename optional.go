@@ -0,0 +1,196 @@
+package router
+
+import "strings"
+
+// expansion is a path expanded from a route path with optional parts.
+type expansion struct {
+	path string
+	pos  []int // offset in the route path of each byte of path, nil if equal
+}
+
+// offset returns the offset in the route path of path[i], or of the end of
+// path if i == len(path).
+func (x expansion) offset(i int) int {
+	switch {
+	case x.pos == nil:
+		return i
+	case i < len(x.pos):
+		return x.pos[i]
+	case len(x.pos) > 0:
+		return x.pos[len(x.pos)-1] + 1
+	}
+	return 0
+}
+
+// patternError returns err, an error of the registration of the expanded
+// path, with the route path and the offset in it.
+func (x expansion) patternError(pattern string, err error) error {
+	switch err := err.(type) {
+	case *InvalidPatternError:
+		err.Path, err.Pos = pattern, x.offset(err.Pos)
+	case *ConflictError:
+		err.Path, err.Pos = pattern, x.offset(err.Pos)
+	}
+	return err
+}
+
+// expandOptional expands the optional parts of a route path into the paths
+// which are registered for it, the path with all optional parts first.
+//
+// Optional parts are enclosed in parentheses, start with '/' and may be
+// nested, e.g. /archive(/:year(/:month)) expands to /archive/:year/:month,
+// /archive/:year and /archive. A param segment ending with '?' is short for a
+// parenthesized segment, /posts/:page? is the same as /posts(/:page). An
+// optional part at the root expands to '/', /:page? to /:page and /.
+//
+// Other parentheses are static text, e.g. in /wiki/Go_(language). They must
+// be balanced and can not be used within optional parts, where a ')' would
+// be ambiguous.
+func expandOptional(path string) ([]string, error) {
+	expanded, err := expandPattern(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(expanded))
+	for i, x := range expanded {
+		paths[i] = x.path
+	}
+	return paths, nil
+}
+
+// expandPattern is like expandOptional, but returns the offsets of the
+// expanded paths in path.
+func expandPattern(path string) ([]expansion, error) {
+	if !strings.ContainsAny(path, "()?") {
+		return []expansion{{path: path}}, nil
+	}
+
+	expanded, _, err := expandSequence(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// without its optional parts, a route path may be empty
+	for i := range expanded {
+		if expanded[i].path == "" {
+			expanded[i] = expansion{"/", []int{0}}
+		}
+	}
+	return combine(expanded, []expansion{{}}), nil
+}
+
+// expandSequence expands the path starting at path[i] up to the ')' closing
+// the optional part at the given depth, or up to the path end. It returns the
+// expansions and the position of the closing ')'.
+func expandSequence(path string, i, depth int) ([]expansion, int, error) {
+	invalid := func(pos int, reason string) ([]expansion, int, error) {
+		return nil, 0, &InvalidPatternError{Path: path, Pos: pos, Reason: reason}
+	}
+
+	paths := []expansion{{}}
+	static, staticPos := 0, 0 // number and position of unclosed static '('
+	for i < len(path) {
+		switch c := path[i]; {
+		case c == '(' && optionalStart(path, i):
+			optional, end, err := expandSequence(path, i+1, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if end >= len(path) {
+				return invalid(i, "unterminated optional part")
+			}
+			paths = combine(paths, append(optional, expansion{}))
+			i = end + 1
+
+		case c == '(' && depth > 0:
+			return invalid(i, "optional parts can not contain static '('")
+
+		case c == '(' && i+1 < len(path) && path[i+1] == ')':
+			return invalid(i, "empty parentheses")
+
+		case c == ')' && depth > 0:
+			return paths, i, nil
+
+		case c == ')' && static == 0:
+			return invalid(i, "unbalanced ')'")
+
+		case c == ':' || c == '*':
+			// the constraint of the wildcard may contain parentheses and '?'
+			end := wildcardEnd(path, i)
+			wildcard := path[i:end]
+			if end == len(path) || path[end] != '?' {
+				paths = combine(paths, []expansion{{wildcard, offsets(i, end)}})
+				i = end
+				continue
+			}
+			if c == '*' {
+				return invalid(i, "catch-all routes can not be optional")
+			}
+			if i == 0 || path[i-1] != '/' || end+1 < len(path) && strings.IndexByte("/)", path[end+1]) < 0 {
+				return invalid(i, "optional params must span a whole path segment")
+			}
+
+			// the '/' in front of the param is part of the optional segment
+			for j, x := range paths {
+				paths[j] = expansion{x.path[:len(x.path)-1], x.pos[:len(x.pos)-1]}
+			}
+			paths = combine(paths, []expansion{{"/" + wildcard, offsets(i-1, end)}, {}})
+			i = end + 1
+
+		default:
+			if c == '(' {
+				if static == 0 {
+					staticPos = i
+				}
+				static++
+			} else if c == ')' {
+				static--
+			}
+			for j := range paths {
+				paths[j].path += string(c)
+				paths[j].pos = append(paths[j].pos, i)
+			}
+			i++
+		}
+	}
+	if static > 0 {
+		return invalid(staticPos, "unbalanced '('")
+	}
+	return paths, i, nil
+}
+
+// optionalStart reports whether the '(' at path[i] starts an optional part,
+// which is the case if it or the first part nested in it starts with '/'.
+func optionalStart(path string, i int) bool {
+	for i < len(path) && path[i] == '(' {
+		i++
+	}
+	return i < len(path) && path[i] == '/'
+}
+
+// combine returns all concatenations of a prefix and a suffix, without
+// duplicates.
+func combine(prefixes, suffixes []expansion) []expansion {
+	paths := make([]expansion, 0, len(prefixes)*len(suffixes))
+	seen := make(map[string]bool, cap(paths))
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			if path := prefix.path + suffix.path; !seen[path] {
+				seen[path] = true
+				pos := make([]int, 0, len(path))
+				pos = append(append(pos, prefix.pos...), suffix.pos...)
+				paths = append(paths, expansion{path, pos})
+			}
+		}
+	}
+	return paths
+}
+
+// offsets returns the offsets from start up to end.
+func offsets(start, end int) []int {
+	pos := make([]int, end-start)
+	for i := range pos {
+		pos[i] = start + i
+	}
+	return pos
+}
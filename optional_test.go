@@ -0,0 +1,62 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandOptional(t *testing.T) {
+	tests := []struct {
+		path  string
+		paths []string
+	}{
+		{"/posts", []string{"/posts"}},
+		{"/posts/:page?", []string{"/posts/:page", "/posts"}},
+		{"/posts/:page<int>?", []string{"/posts/:page<int>", "/posts"}},
+		{"/posts/:page?/comments", []string{"/posts/:page/comments", "/posts/comments"}},
+		{"/archive(/:year(/:month))", []string{"/archive/:year/:month", "/archive/:year", "/archive"}},
+		{"/a(/b)/c(/d)", []string{"/a/b/c/d", "/a/b/c", "/a/c/d", "/a/c"}},
+		{"/items/:id<^(a|b)$>?", []string{"/items/:id<^(a|b)$>", "/items"}},
+		{"/x((/y))", []string{"/x/y", "/x"}},
+		// optional segments at the root expand to '/'
+		{"/:page?", []string{"/:page", "/"}},
+		{"(/x)", []string{"/x", "/"}},
+		{"(/x)(/y)", []string{"/x/y", "/x", "/y", "/"}},
+		// parentheses not starting with '/' are static text
+		{"/wiki/Go_(language)", []string{"/wiki/Go_(language)"}},
+		{"/files/:name(.json)", []string{"/files/:name(.json)"}},
+		{"/f(x(/:y))", []string{"/f(x/:y)", "/f(x)"}},
+		{"/search/:q<a?>", []string{"/search/:q<a?>"}},
+		{"/what?", []string{"/what?"}},
+	}
+	for _, test := range tests {
+		paths, err := expandOptional(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s: expanded to %q, want %q", test.path, paths, test.paths)
+		}
+	}
+
+	invalid := []string{
+		"/archive(/:year",
+		"/archive/:year)",
+		"/archive()",
+		"/static/*filepath?",
+		"/posts-:page?",
+		"/posts/:page?.json",
+		"/wiki/Go_(language",
+		"/wiki/Go_language)",
+		"/archive(/:year(x))",
+		"/archive(/:year)x)",
+	}
+	for _, path := range invalid {
+		if _, err := expandOptional(path); err == nil {
+			t.Errorf("%s: no error", path)
+		} else if _, ok := err.(*InvalidPatternError); !ok {
+			t.Errorf("%s: error %T, want *InvalidPatternError", path, err)
+		}
+	}
+}
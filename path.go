@@ -18,6 +18,9 @@ package router
 //	   that is, replace "/.." by "/" at the beginning of a path.
 //
 // If the result of this process is an empty string, "/" is returned
+//
+// Escaped paths can be cleaned as well, escapes are kept as is and an escaped
+// slash is not treated as a separator.
 func CleanPath(p string) string {
 	// Turn empty string into "/"
 	if p == "" {
@@ -62,6 +62,12 @@ var cleanTests = []struct {
 	{"abc/./../def", "/def"},
 	{"abc//./../def", "/def"},
 	{"abc/../../././../def", "/def"},
+
+	// Escaped paths, escapes are kept
+	{"/a%2Fb/c", "/a%2Fb/c"},
+	{"/a%2Fb/../c", "/c"},
+	{"/a/%2E%2E/b", "/a/%2E%2E/b"},
+	{"//a%20b//", "/a%20b/"},
 }
 
 func TestPathClean(t *testing.T) {
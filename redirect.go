@@ -0,0 +1,122 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/goa-go/goa"
+)
+
+// RedirectPolicy configures how requests are redirected to the corrected path
+// if RedirectTrailingSlash or RedirectFixedPath is enabled.
+//
+// router.RedirectPolicy = &router.RedirectPolicy{GETCode: 308, OtherCode: 308}
+type RedirectPolicy struct {
+	// GETCode is the status code of redirects of GET requests, one of 301,
+	// 302, 307 and 308. It defaults to 301.
+	GETCode int
+
+	// OtherCode is the status code of redirects of requests with other
+	// methods. It defaults to 307, which like 308 makes clients repeat the
+	// request with the same method and body.
+	OtherCode int
+
+	// DropQuery drops the query string of the request from the location of
+	// the redirect.
+	DropQuery bool
+
+	// Handler, if set, is called instead of redirecting with the location
+	// and status code of the redirect, e.g. to log it or to add headers.
+	Handler func(c *goa.Context, location string, code int)
+
+	// ServeInPlace serves the corrected route directly instead of
+	// redirecting, for clients which do not follow redirects. The corrected
+	// path is exposed in the Content-Location header and in c.Path, the
+	// request URL is left unchanged. The other options are ignored.
+	ServeInPlace bool
+}
+
+// Redirect sets the redirect policy of requests which are redirected to the
+// route, overriding the policy of the router, see Router.RedirectPolicy.
+func (rt *Route) Redirect(policy RedirectPolicy) *Route {
+	rt.redirect.Store(&policy)
+	return rt
+}
+
+// redirectPolicy returns the redirect policy of the route, nil if the
+// router's policy applies.
+func (rt *Route) redirectPolicy() *RedirectPolicy {
+	policy, _ := rt.redirect.Load().(*RedirectPolicy)
+	return policy
+}
+
+// redirect redirects the request to the given path of the tree root, which
+// replaces c.Path in the request URL, or serves the route of the path in
+// place, see RedirectPolicy.ServeInPlace. hostParams are the params captured
+// from the request host. raw reports whether path is escaped, see UseRawPath.
+func (r *Router) redirect(c *goa.Context, root *node, path string, hostParams goa.Params, raw bool) {
+	var ps goa.Params
+	leaf, _ := r.lookup(root, path, &ps)
+	if leaf != nil && leaf.route.Disabled() {
+		leaf = nil
+	}
+
+	policy := r.RedirectPolicy
+	if leaf != nil {
+		if p := leaf.route.redirectPolicy(); p != nil {
+			policy = p
+		}
+	}
+	if policy == nil {
+		policy = &RedirectPolicy{}
+	}
+
+	unescaped := path
+	if raw {
+		if p, err := url.PathUnescape(path); err == nil {
+			unescaped = p
+		}
+	}
+
+	// a prefix stripped from c.Path by a mount is kept
+	u := *c.URL
+	u.Path = unescaped
+	if strings.HasSuffix(c.URL.Path, c.Path) {
+		u.Path = c.URL.Path[:len(c.URL.Path)-len(c.Path)] + unescaped
+	}
+	u.RawPath = ""
+	if raw {
+		escaped := c.URL.EscapedPath()
+		rest, _ := rawPath(c)
+		u.RawPath = escaped[:len(escaped)-len(rest)] + path
+	}
+	c.Path = unescaped
+
+	if policy.ServeInPlace && leaf != nil {
+		c.SetHeader("Content-Location", u.EscapedPath())
+		r.serve(c, leaf, hostParams, &ps, raw)
+		return
+	}
+
+	code := policy.OtherCode
+	if c.Method == "GET" {
+		code = policy.GETCode
+		if code == 0 {
+			code = http.StatusMovedPermanently
+		}
+	} else if code == 0 {
+		code = http.StatusTemporaryRedirect
+	}
+
+	if policy.DropQuery {
+		u.RawQuery = ""
+		u.ForceQuery = false
+	}
+
+	if policy.Handler != nil {
+		policy.Handler(c, u.String(), code)
+		return
+	}
+	c.Redirect(code, u.String())
+}
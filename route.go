@@ -0,0 +1,224 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Route is a registered route. It is returned by Register and the method
+// shortcuts, so that the route can be configured further.
+type Route struct {
+	router *Router
+	method string
+	path   string
+	name   string
+	doc    *Operation
+
+	// set to 1 by Disable, accessed atomically
+	disabled int32
+
+	// *RedirectPolicy set by Redirect
+	redirect atomic.Value
+}
+
+// Name names the route, so that URLs for it can be built with Router.URL.
+// Names must be unique per router.
+//
+// router.GET("/users/:id", handler).Name("user")
+func (rt *Route) Name(name string) *Route {
+	err := rt.router.update(func(t *table) error {
+		if existing := t.names.get(name); existing != nil && existing != rt {
+			return fmt.Errorf("route name '%s' is already registered for path '%s'",
+				name, existing.path)
+		}
+
+		if rt.name != "" && rt.name != name {
+			t.names = t.names.set(rt.name, nil)
+		}
+		rt.name = name
+		t.names = t.names.set(name, rt)
+		return nil
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+	return rt
+}
+
+// Disable disables the route without unregistering it, requests to it are
+// answered with 405 or 404 as if it was not registered. Other routes matching
+// the request are not tried instead.
+func (rt *Route) Disable() *Route {
+	atomic.StoreInt32(&rt.disabled, 1)
+	return rt
+}
+
+// Enable enables the route after it was disabled.
+func (rt *Route) Enable() *Route {
+	atomic.StoreInt32(&rt.disabled, 0)
+	return rt
+}
+
+// Disabled reports whether the route is disabled.
+func (rt *Route) Disabled() bool {
+	return atomic.LoadInt32(&rt.disabled) != 0
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method string
+	// Path is the path the route was registered with, e.g. /users/:id.
+	// Routes with optional parts are listed once for each of their paths,
+	// e.g. /posts/:page? as /posts/:page and /posts.
+	Path string
+	// Name is the route name, see Route.Name. It is empty for unnamed routes.
+	Name string
+	// Params are the names of the wildcards of Path, in order.
+	Params []string
+}
+
+// List returns all registered routes, sorted by path and method.
+func (r *Router) List() []RouteInfo {
+	// route names are modified while holding the lock
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var routes []RouteInfo
+	for method, root := range r.load().trees {
+		root.walk(func(leaf *node) {
+			routes = append(routes, RouteInfo{
+				Method: method,
+				Path:   leaf.fullPath,
+				Name:   leaf.route.name,
+				Params: paramNames(leaf.fullPath),
+			})
+		})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// paramNames returns the names of the wildcards of the route path.
+func paramNames(path string) []string {
+	var names []string
+	for i := 0; i < len(path); i++ {
+		if path[i] != ':' && path[i] != '*' {
+			continue
+		}
+		end := wildcardEnd(path, i)
+		name, _ := splitWildcard(path[i:end])
+		names = append(names, name)
+		i = end - 1
+	}
+	return names
+}
+
+// URL builds the URL path of the route with the given name.
+// The params are key-value pairs filling the wildcards of the route pattern,
+// e.g. URL("user", "id", "42"). Param values are escaped, catch-all values may
+// contain slashes.
+// Optional parts of the pattern are left out if their params have no value.
+// An error is returned if no route has the given name, if a wildcard of the
+// pattern has no value or if a param does not belong to the pattern.
+func (r *Router) URL(name string, params ...string) (string, error) {
+	route := r.load().names.get(name)
+	if route == nil {
+		return "", fmt.Errorf("no route named '%s'", name)
+	}
+
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("odd number of params for route '%s'", name)
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	// the pattern was validated at registration
+	paths, _ := expandOptional(route.path)
+	for _, path := range paths {
+		if hasParams(path, values) {
+			return buildURL(name, path, values)
+		}
+	}
+	return buildURL(name, paths[0], values)
+}
+
+// hasParams reports whether values has a non-empty value for each wildcard
+// of the path, and no other values.
+func hasParams(path string, values map[string]string) bool {
+	names := paramNames(path)
+	if len(names) != len(values) {
+		return false
+	}
+	for _, name := range names {
+		if values[name] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// buildURL fills the wildcards of the path of the named route with the
+// values, see Router.URL.
+func buildURL(name, path string, values map[string]string) (string, error) {
+	buf := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != ':' && c != '*' {
+			buf = append(buf, c)
+			continue
+		}
+
+		end := wildcardEnd(path, i)
+		key, pattern := splitWildcard(path[i:end])
+		i = end - 1
+
+		value, ok := values[key]
+		if !ok || value == "" {
+			return "", fmt.Errorf("missing value for param '%s' of route '%s'", key, name)
+		}
+		delete(values, key)
+
+		if pattern != "" {
+			// the constraint was validated at registration
+			if cons, _ := newConstraint(pattern); !cons.match(value) {
+				return "", fmt.Errorf("value '%s' does not satisfy the constraint '<%s>' of param '%s' of route '%s'",
+					value, pattern, key, name)
+			}
+		}
+
+		if c == ':' {
+			buf = append(buf, url.PathEscape(value)...)
+			continue
+		}
+
+		// catch-all values contain the leading '/', which is already part
+		// of the pattern
+		segments := strings.Split(strings.TrimPrefix(value, "/"), "/")
+		for j := range segments {
+			segments[j] = url.PathEscape(segments[j])
+		}
+		buf = append(buf, strings.Join(segments, "/")...)
+	}
+
+	if len(values) > 0 {
+		extra := make([]string, 0, len(values))
+		for key := range values {
+			extra = append(extra, key)
+		}
+		sort.Strings(extra)
+		return "", fmt.Errorf("unknown params '%s' for route '%s'", strings.Join(extra, "', '"), name)
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterURL(t *testing.T) {
+	h := func(c *goa.Context) {}
+
+	router := New()
+	router.GET("/", h).Name("index")
+	router.GET("/users/:id", h).Name("user")
+	router.GET("/users/:id/posts/:post", h).Name("post")
+	router.GET("/src/*filepath", h).Name("src")
+	router.Group("/api").POST("/items/:item", h).Name("item")
+	router.GET("/files/:name.:ext<[a-z]+>", h).Name("file")
+	router.GET("/repos/*path/blob/:ref", h).Name("blob")
+
+	tests := []struct {
+		name   string
+		params []string
+		url    string
+	}{
+		{"index", nil, "/"},
+		{"user", []string{"id", "42"}, "/users/42"},
+		{"user", []string{"id", "a/b c"}, "/users/a%2Fb%20c"},
+		{"post", []string{"post", "7", "id", "42"}, "/users/42/posts/7"},
+		{"src", []string{"filepath", "/some/file name.go"}, "/src/some/file%20name.go"},
+		{"src", []string{"filepath", "some/file.go"}, "/src/some/file.go"},
+		{"item", []string{"item", "x"}, "/api/items/x"},
+		{"file", []string{"name", "main", "ext", "go"}, "/files/main.go"},
+		{"blob", []string{"path", "/goa/router", "ref", "master"}, "/repos/goa/router/blob/master"},
+	}
+	for _, test := range tests {
+		url, err := router.URL(test.name, test.params...)
+		if err != nil {
+			t.Errorf("URL(%q, %q): unexpected error: %v", test.name, test.params, err)
+		} else if url != test.url {
+			t.Errorf("URL(%q, %q) = %q, want %q", test.name, test.params, url, test.url)
+		}
+	}
+
+	errTests := []struct {
+		name   string
+		params []string
+	}{
+		{"unknown", nil},
+		{"user", nil},
+		{"user", []string{"id"}},
+		{"user", []string{"id", ""}},
+		{"user", []string{"id", "42", "extra", "1"}},
+		{"index", []string{"id", "42"}},
+		{"file", []string{"name", "main", "ext", "GO"}},
+	}
+	for _, test := range errTests {
+		if url, err := router.URL(test.name, test.params...); err == nil {
+			t.Errorf("URL(%q, %q) = %q, want error", test.name, test.params, url)
+		}
+	}
+}
+
+func TestRouteNameConflict(t *testing.T) {
+	h := func(c *goa.Context) {}
+
+	router := New()
+	route := router.GET("/a", h).Name("a")
+	router.GET("/b", h).Name("b")
+
+	// renaming a route is fine
+	route.Name("a")
+	route.Name("c")
+	if _, err := router.URL("a"); err == nil {
+		t.Error("old route name still registered after renaming")
+	}
+	if url, err := router.URL("c"); err != nil || url != "/a" {
+		t.Errorf("URL(\"c\") = %q, %v; want \"/a\"", url, err)
+	}
+
+	recv := catchPanic(func() {
+		router.GET("/d", h).Name("b")
+	})
+	if recv == nil {
+		t.Error("registering duplicate route name did not panic")
+	}
+}
+
+func TestRouterList(t *testing.T) {
+	h := func(c *goa.Context) {}
+
+	router := New()
+	if routes := router.List(); len(routes) != 0 {
+		t.Errorf("routes of empty router: %v", routes)
+	}
+
+	router.POST("/users", h)
+	router.GET("/users/:id<int>/posts/:post", h).Name("post")
+	router.GET("/users", h).Name("users")
+	router.DELETE("/users/:id", h)
+	router.GET("/users/new", h)
+	router.ServeFiles("/src/*filepath", &mockFileSystem{})
+
+	want := []RouteInfo{
+		{Method: "GET", Path: "/src/*filepath", Params: []string{"filepath"}},
+		{Method: "GET", Path: "/users", Name: "users"},
+		{Method: "POST", Path: "/users"},
+		{Method: "DELETE", Path: "/users/:id", Params: []string{"id"}},
+		{Method: "GET", Path: "/users/:id<int>/posts/:post", Name: "post", Params: []string{"id", "post"}},
+		{Method: "GET", Path: "/users/new"},
+	}
+	if routes := router.List(); !reflect.DeepEqual(routes, want) {
+		t.Errorf("wrong routes:\n got %v\nwant %v", routes, want)
+	}
+}
+
+func TestRouteDisable(t *testing.T) {
+	routed := ""
+	router := New()
+	user := router.GET("/users/:id", func(c *goa.Context) { routed = "get" })
+	router.GET("/users/new", func(c *goa.Context) { routed = "new" })
+	router.PUT("/users/:id", func(c *goa.Context) { routed = "put" })
+
+	serve := func(method, path string) int {
+		routed = ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	user.Disable()
+	if !user.Disabled() {
+		t.Error("route not disabled")
+	}
+	if code := serve("GET", "/users/42"); routed != "" || code != http.StatusMethodNotAllowed {
+		t.Errorf("disabled route was served: routed=%q, code=%d", routed, code)
+	}
+	if serve("GET", "/users/new"); routed != "new" {
+		t.Error("other route was not served")
+	}
+
+	router.Remove("PUT", "/users/:id")
+	if code := serve("GET", "/users/42"); routed != "" || code != http.StatusNotFound {
+		t.Errorf("disabled route was served: routed=%q, code=%d", routed, code)
+	}
+
+	user.Enable()
+	if serve("GET", "/users/42"); routed != "get" {
+		t.Error("enabled route was not served")
+	}
+}
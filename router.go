@@ -6,6 +6,9 @@ package router
 
 import (
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 
 	"github.com/goa-go/goa"
 )
@@ -13,10 +16,37 @@ import (
 // Handler is the type of goa-router handle function.
 type Handler func(*goa.Context)
 
+// Middleware is the type of goa-router route middleware.
+// It is called with the request context and a next function which runs the
+// rest of the chain, so code after next() runs after the handler returned.
+type Middleware func(c *goa.Context, next func())
+
+// compose wraps handler with the given middleware, the first middleware being
+// the outermost one.
+func compose(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		mw, next := middleware[i], handler
+		handler = func(c *goa.Context) {
+			mw(c, func() { next(c) })
+		}
+	}
+	return handler
+}
+
 // Router is a http.Handler which can be used to dispatch requests to different
 // handler functions via configurable routes
 type Router struct {
-	trees map[string]*node
+	// current route table, see update
+	table atomic.Value
+	// serializes writers of the route table
+	mu sync.Mutex
+
+	// buffers of the params of requests, see getParams
+	paramsPool sync.Pool
+
+	// goa app serving requests, see ServeHTTP
+	appOnce sync.Once
+	app     *goa.Goa
 
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
@@ -25,6 +55,30 @@ type Router struct {
 	// and 307 for all other request methods.
 	RedirectTrailingSlash bool
 
+	// Configures the redirects of RedirectTrailingSlash and RedirectFixedPath,
+	// e.g. their status codes, or serves the corrected paths without
+	// redirecting, see RedirectPolicy. Routes can override it with
+	// Route.Redirect.
+	// If nil, requests are redirected with status code 301 for GET requests
+	// and 307 for all other request methods.
+	RedirectPolicy *RedirectPolicy
+
+	// If enabled, paths are matched case-insensitively, e.g. /Users/42 is
+	// routed to /users/:id. Param values keep their case. Routes matching
+	// with the exact case take priority.
+	CaseInsensitive bool
+
+	// If enabled, requests are routed by the escaped request path, if it
+	// differs from the default escaping of the path, e.g. because it contains
+	// an escaped slash. A param value like a%2Fb then matches a single
+	// segment, instead of being split into two. The escaped path is only used
+	// for routing, c.Path stays unescaped.
+	UseRawPath bool
+
+	// If enabled, param values are unescaped if the request was routed by the
+	// escaped path, see UseRawPath.
+	UnescapePathValues bool
+
 	// If enabled, the router tries to fix the current request path, if no
 	// handler is registered for it.
 	// First superfluous path elements like ../ or // are removed.
@@ -44,6 +98,11 @@ type Router struct {
 	// handler.
 	HandleMethodNotAllowed bool
 
+	// If enabled, HEAD requests to paths without a HEAD route are served by
+	// the GET route of the path. The response body is discarded, the headers
+	// including the Content-Length of the body are sent.
+	HandleHEADFromGET bool
+
 	// If enabled, the router automatically replies to OPTIONS requests.
 	// Custom OPTIONS handlers take priority over automatic replies.
 	HandleOPTIONS bool
@@ -58,10 +117,30 @@ type Router struct {
 	// is called.
 	MethodNotAllowed Handler
 
+	// If enabled, the registered path of the matched route, e.g. /users/:id,
+	// is saved in the context before the handler is called. It can be read
+	// by handlers and by middleware running after Routes() with MatchedRoute.
+	// Routes of mounted routers are saved with the mount prefix in front if
+	// either router has it enabled.
+	SaveMatchedRoute bool
+
 	// All allow methods
 	Methods []string
 }
 
+// MatchedRouteKey is the context key the matched route path is saved under
+// if SaveMatchedRoute is enabled.
+const MatchedRouteKey = "router.matchedRoute"
+
+// MatchedRoute returns the registered path of the route which matched the
+// request, e.g. /users/:id, or "" if no route matched.
+// The Router must have SaveMatchedRoute enabled.
+func MatchedRoute(c *goa.Context) string {
+	route, _ := c.Get(MatchedRouteKey)
+	path, _ := route.(string)
+	return path
+}
+
 // New returns a new initialized Router.
 // Path auto-correction, including trailing slashes, is enabled by default.
 func New() *Router {
@@ -74,41 +153,70 @@ func New() *Router {
 }
 
 // GET registers a new request handle with the given path and get method.
-func (r *Router) GET(path string, handler Handler) {
-	r.Register("GET", path, handler)
+func (r *Router) GET(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("GET", path, handler, middleware...)
 }
 
 // HEAD registers a new request handle with the given path and head method.
-func (r *Router) HEAD(path string, handler Handler) {
-	r.Register("HEAD", path, handler)
+func (r *Router) HEAD(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("HEAD", path, handler, middleware...)
 }
 
 // OPTIONS registers a new request handle with the given path and options method.
-func (r *Router) OPTIONS(path string, handler Handler) {
-	r.Register("OPTIONS", path, handler)
+func (r *Router) OPTIONS(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("OPTIONS", path, handler, middleware...)
 }
 
 // POST registers a new request handle with the given path and post method.
-func (r *Router) POST(path string, handler Handler) {
-	r.Register("POST", path, handler)
+func (r *Router) POST(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("POST", path, handler, middleware...)
 }
 
 // PUT registers a new request handle with the given path and put method.
-func (r *Router) PUT(path string, handler Handler) {
-	r.Register("PUT", path, handler)
+func (r *Router) PUT(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("PUT", path, handler, middleware...)
 }
 
 // PATCH registers a new request handle with the given path and patch method.
-func (r *Router) PATCH(path string, handler Handler) {
-	r.Register("PATCH", path, handler)
+func (r *Router) PATCH(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("PATCH", path, handler, middleware...)
 }
 
 // DELETE registers a new request handle with the given path and delete method.
-func (r *Router) DELETE(path string, handler Handler) {
-	r.Register("DELETE", path, handler)
+func (r *Router) DELETE(path string, handler Handler, middleware ...Middleware) *Route {
+	return r.Register("DELETE", path, handler, middleware...)
 }
 
 // Register registers a new request handle with the given path and method.
+// The optional middleware wraps the handler in the given order, the first
+// middleware being the outermost one.
+// The returned Route can be used to name the route, see Route.Name.
+//
+// Parts of the path enclosed in parentheses and starting with '/' are
+// optional and may be nested, a param segment ending with '?' is optional as
+// well. Absent optional params are not set in c.Params. Other parentheses are
+// static text, they must be balanced and can not be used in optional parts.
+//
+// router.GET("/archive(/:year(/:month))", archive)
+// router.GET("/posts/:page?", posts)
+//
+// A path segment may hold several params separated by static text. A param
+// ends in front of the first occurrence of the text following it up to the
+// next param, /files/a.tar.gz matches /files/:name.:ext with name "a" and ext
+// "tar.gz". Later occurrences are not tried if the rest of the path does not
+// match. Routes continuing a segment after a param are tried before the route
+// whose param spans the whole segment. Params at the same position with the
+// same constraint must have the same name, whatever follows them. Param names
+// consist of letters, digits and '_'.
+//
+// router.GET("/v:major<int>.:minor<int>/status", status)
+//
+// A catch-all may be followed by static text and further segments. It ends in
+// front of the last occurrence of the text following it up to the next
+// wildcard, and is tried after the static and param routes at its position.
+// Two catch-alls must be separated by more than a '/'.
+//
+// router.GET("/repos/*path/blob/:ref", blob)
 //
 // For GET, POST, PUT, PATCH and DELETE requests the respective shortcut
 // functions can be used.
@@ -116,55 +224,142 @@ func (r *Router) DELETE(path string, handler Handler) {
 // This function is intended for bulk loading and to allow the usage of less
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
-func (r *Router) Register(method, path string, handler Handler) {
-	if path[0] != '/' {
-		panic("path must begin with '/' in path '" + path + "'")
+//
+// Register panics if the path is malformed or conflicts with a registered
+// route, use TryRegister to handle these errors instead.
+func (r *Router) Register(method, path string, handler Handler, middleware ...Middleware) *Route {
+	route, err := r.TryRegister(method, path, handler, middleware...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return route
+}
+
+// TryRegister is like Register but returns an error instead of panicking.
+// The error is an *InvalidPatternError if the path is malformed, or a
+// *ConflictError if the path conflicts with a registered route, or ErrFrozen
+// if the router is frozen. The router is left unchanged if an error is
+// returned.
+func (r *Router) TryRegister(method, path string, handler Handler, middleware ...Middleware) (*Route, error) {
+	expanded, err := expandPattern(path)
+	if err != nil {
+		return nil, err
 	}
 
-	if r.trees == nil {
-		r.trees = make(map[string]*node)
+	route := &Route{router: r, method: method, path: path}
+	err = r.update(func(t *table) error {
+		root := t.trees[method]
+		if root == nil {
+			root = new(node)
+		}
+
+		// the paths of the optional parts share the route
+		static := false
+		for _, x := range expanded {
+			var leaf *node
+			var err error
+			if root, leaf, err = root.insertRoute(x.path, handler, middleware...); err != nil {
+				return x.patternError(path, err)
+			}
+			leaf.route = route
+			static = static || countParams(x.path) == 0
+		}
+		t.trees[method] = root
+		if static {
+			t.static[method] = &staticIndex{root: root}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return route, nil
+}
 
-	root := r.trees[method]
-	if root == nil {
-		root = new(node)
-		r.trees[method] = root
+// Remove unregisters the route registered with the given method and path,
+// e.g. Remove("GET", "/users/:id"). The path must be the path the route was
+// registered with. Remove returns false if no such route is registered.
+func (r *Router) Remove(method, path string) bool {
+	paths, err := expandOptional(path)
+	if err != nil {
+		return false
 	}
 
-	root.addRoute(path, handler)
+	removed := false
+	r.update(func(t *table) error {
+		root := t.trees[method]
+		static := false
+		for _, p := range paths {
+			if root == nil {
+				break
+			}
+
+			// only the paths of the route itself are removed, not those of
+			// a route with optional parts expanding to the path
+			rest, leaf := root.remove(p)
+			if leaf == nil || leaf.route.path != path {
+				continue
+			}
+			root = rest
+			removed = true
+			static = static || countParams(p) == 0
+			if route := leaf.route; route.name != "" && t.names.get(route.name) == route {
+				t.names = t.names.set(route.name, nil)
+			}
+		}
+
+		if root == nil {
+			delete(t.trees, method)
+			delete(t.static, method)
+		} else {
+			t.trees[method] = root
+			if static {
+				t.static[method] = &staticIndex{root: root}
+			}
+		}
+		return nil
+	})
+	return removed
 }
 
 func (r *Router) allowed(path, reqMethod string) (allow string) {
+	add := func(method string) {
+		// add request method to list of allowed methods
+		if len(allow) == 0 {
+			allow = method
+		} else {
+			allow += ", " + method
+		}
+	}
+
+	trees := r.load().trees
 	if path == "*" { // server-wide
-		for method := range r.trees {
+		for method := range trees {
 			if method == "OPTIONS" {
 				continue
 			}
-
-			// add request method to list of allowed methods
-			if len(allow) == 0 {
-				allow = method
-			} else {
-				allow += ", " + method
-			}
+			add(method)
+		}
+		if trees["GET"] != nil && trees["HEAD"] == nil && r.HandleHEADFromGET {
+			add("HEAD")
 		}
 	} else { // specific path
-		for method := range r.trees {
+		getAllowed, headAllowed := false, false
+		for method := range trees {
 			// Skip the requested method - we already tried this one
 			if method == reqMethod || method == "OPTIONS" {
 				continue
 			}
 
-			handle, _, _ := r.trees[method].getValue(path)
-			if handle != nil {
-				// add request method to list of allowed methods
-				if len(allow) == 0 {
-					allow = method
-				} else {
-					allow += ", " + method
-				}
+			if leaf, _ := r.lookup(trees[method], path, nil); leaf != nil && !leaf.route.Disabled() {
+				add(method)
+				getAllowed = getAllowed || method == "GET"
+				headAllowed = headAllowed || method == "HEAD"
 			}
 		}
+		if getAllowed && !headAllowed && reqMethod != "HEAD" && r.HandleHEADFromGET {
+			add("HEAD")
+		}
 	}
 	if len(allow) > 0 {
 		allow += ", OPTIONS"
@@ -183,68 +378,270 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 // use http.Dir:
 // router.ServeFiles("/src/*filepath", http.Dir("/var/www"))
 func (r *Router) ServeFiles(path string, root http.FileSystem) {
+	if err := r.TryServeFiles(path, root); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryServeFiles is like ServeFiles but returns an error instead of panicking,
+// see TryRegister.
+func (r *Router) TryServeFiles(path string, root http.FileSystem) error {
+	handler, err := fileHandler(path, root)
+	if err != nil {
+		return err
+	}
+	_, err = r.TryRegister("GET", path, handler)
+	return err
+}
+
+// fileHandler returns the handler used by ServeFiles.
+func fileHandler(path string, root http.FileSystem) (Handler, error) {
 	if len(path) < 10 || path[len(path)-10:] != "/*filepath" {
-		panic("path must end with /*filepath in path '" + path + "'")
+		return nil, &InvalidPatternError{Path: path, Pos: len(path),
+			Reason: "path must end with /*filepath"}
 	}
 
 	fileServer := http.FileServer(root)
 
-	r.GET(path, func(c *goa.Context) {
+	return func(c *goa.Context) {
 		c.URL.Path = c.Param("filepath")
 		fileServer.ServeHTTP(c.ResponseWriter, c.Request)
 		c.Handled = true
-	})
+	}, nil
+}
+
+// Group returns a new route group. Every route registered through the group
+// is prefixed with prefix and wrapped by the given middleware.
+func (r *Router) Group(prefix string, middleware ...Middleware) *Group {
+	return newGroup(r, prefix, middleware)
 }
 
 // Handle is goa-router's handle function.
+//
+// The params of a route are stored in a buffer which is reused for other
+// requests after the handler returned, c.Params is reset then. Handlers must
+// copy the params they keep beyond the request, e.g. for a goroutine.
 func (r *Router) Handle(c *goa.Context) {
-	path := c.Path
+	router, hostParams := r, goa.Params(nil)
+	if t := r.load(); (len(t.hosts) > 0 || len(t.hostPatterns) > 0) && c.Request != nil {
+		if sub, ps := t.matchHost(c.Request.Host); sub != nil {
+			router, hostParams = sub, ps
+		}
+	}
+
+	if !router.handle(c, hostParams) {
+		router.unhandled(c)
+	}
+}
+
+// handle routes the request by its path, hostParams are the params captured
+// from the request host. It returns false if neither a route nor a mount
+// matched and the request was not redirected.
+func (r *Router) handle(c *goa.Context, hostParams goa.Params) bool {
+	path, raw := r.routingPath(c)
+	t := r.load()
+
+	// routes without wildcards are matched exactly, without walking the tree
+	if index := t.static[c.Method]; index != nil {
+		if leaf := index.get(path); leaf != nil {
+			if leaf.route.Disabled() {
+				return false
+			}
+			r.serve(c, leaf, hostParams, nil, raw)
+			return true
+		}
+	}
 
-	if root := r.trees[c.Method]; root != nil {
-		if Handler, ps, tsr := root.getValue(path); Handler != nil {
+	root := t.trees[c.Method]
+	tsr := false
+	if root != nil {
+		ps := r.getParams(root)
+		var leaf *node
+		if leaf, tsr = r.lookup(root, path, ps); leaf != nil {
+			if leaf.route.Disabled() {
+				r.putParams(ps)
+				return false
+			}
+			r.serve(c, leaf, hostParams, ps, raw)
+			return true
+		}
+		r.putParams(ps)
+	}
+
+	if get := t.trees["GET"]; c.Method == "HEAD" && r.HandleHEADFromGET && get != nil {
+		ps := r.getParams(get)
+		if leaf, _ := r.lookup(get, path, ps); leaf != nil && !leaf.route.Disabled() {
+			w := &headWriter{ResponseWriter: c.ResponseWriter}
+			c.ResponseWriter = w
+			defer w.handlerDone()
+			r.serve(c, leaf, hostParams, ps, raw)
+			return true
+		}
+		r.putParams(ps)
+	}
+
+	// mount prefixes are matched on the unescaped path, the mounted router
+	// routes the rest of the path with its own options. Mounted handlers may
+	// keep the params, so they are not pooled.
+	if t.mounts != nil {
+		var ps goa.Params
+		if leaf, _ := r.lookup(t.mounts, c.Path, &ps); leaf != nil {
+			if len(hostParams) > 0 {
+				ps = append(hostParams, ps...)
+			}
 			c.Params = ps
-			Handler(c)
-			return
-		} else if c.Method != "CONNECT" && path != "/" {
-			code := 301 // Permanent redirect, request with GET method
-			if c.Method != "GET" {
-				// Temporary redirect, request with same method
-				// As of Go 1.3, Go does not support status code 308.
-				code = 307
+			leaf.handler(c)
+			return true
+		}
+	}
+
+	if root != nil && c.Method != "CONNECT" && path != "/" {
+		if tsr && r.RedirectTrailingSlash {
+			if len(path) > 1 && path[len(path)-1] == '/' {
+				r.redirect(c, root, path[:len(path)-1], hostParams, raw)
+			} else {
+				r.redirect(c, root, path+"/", hostParams, raw)
 			}
+			return true
+		}
 
-			if tsr && r.RedirectTrailingSlash {
-				if len(path) > 1 && path[len(path)-1] == '/' {
-					c.URL.Path = path[:len(path)-1]
-					c.Path = path[:len(path)-1]
-				} else {
-					c.URL.Path = path + "/"
-					c.Path = path + "/"
-				}
-				c.Redirect(code, c.URL.String())
-				return
+		// Try to fix the request path
+		if r.RedirectFixedPath {
+			fixedPath, found := root.findCaseInsensitivePath(
+				CleanPath(path),
+				r.RedirectTrailingSlash,
+			)
+			if found {
+				r.redirect(c, root, string(fixedPath), hostParams, raw)
+				return true
 			}
+		}
+	}
 
-			// Try to fix the request path
-			if r.RedirectFixedPath {
-				fixedPath, found := root.findCaseInsensitivePath(
-					CleanPath(path),
-					r.RedirectTrailingSlash,
-				)
-				if found {
-					c.Path = string(fixedPath)
-					c.Redirect(code, c.URL.String())
-					return
-				}
+	return false
+}
+
+// routingPath returns the path the request is routed by, which is the
+// escaped path if UseRawPath is enabled and the request path contains escaped
+// slashes or other reserved characters. raw reports whether it is escaped.
+func (r *Router) routingPath(c *goa.Context) (path string, raw bool) {
+	if r.UseRawPath {
+		if path, ok := rawPath(c); ok {
+			return path, true
+		}
+	}
+	return c.Path, false
+}
+
+// rawPath returns the escaped form of c.Path, if the request URL has a raw
+// path.
+func rawPath(c *goa.Context) (string, bool) {
+	if c.URL == nil || c.URL.RawPath == "" {
+		return "", false
+	}
+	escaped := c.URL.EscapedPath()
+	if c.Path == c.URL.Path {
+		return escaped, true
+	}
+
+	// c.Path is the rest of the path below a mount prefix, find the escaped
+	// rest at the segment boundary where it starts
+	for i := len(escaped) - 1; i >= 0; i-- {
+		if escaped[i] != '/' {
+			continue
+		}
+		if rest, err := url.PathUnescape(escaped[i:]); err == nil && rest == c.Path {
+			return escaped[i:], true
+		}
+	}
+	return "", false
+}
+
+// lookup looks up the path in the tree, case-insensitively if enabled. Exact
+// matches take priority over case-insensitive ones. The param values are
+// appended to ps, unless it is nil.
+func (r *Router) lookup(root *node, path string, ps *goa.Params) (*node, bool) {
+	leaf, tsr := root.find(path, ps, false)
+	if leaf == nil && r.CaseInsensitive {
+		return root.find(path, ps, true)
+	}
+	return leaf, tsr
+}
+
+// getParams returns an empty params buffer from the pool, which can hold the
+// params of every route of the tree root. It returns nil if the tree has no
+// params.
+func (r *Router) getParams(root *node) *goa.Params {
+	if root.maxParams == 0 {
+		return nil
+	}
+	ps, _ := r.paramsPool.Get().(*goa.Params)
+	if ps == nil || cap(*ps) < int(root.maxParams) {
+		p := make(goa.Params, 0, root.maxParams)
+		return &p
+	}
+	*ps = (*ps)[:0]
+	return ps
+}
+
+// putParams returns a params buffer to the pool.
+func (r *Router) putParams(ps *goa.Params) {
+	if ps != nil {
+		r.paramsPool.Put(ps)
+	}
+}
+
+// serve calls the handler of the leaf with the params captured from the host
+// and the path, and recycles the params buffer ps after the handler returned.
+// raw reports whether the path was escaped, see UseRawPath.
+func (r *Router) serve(c *goa.Context, leaf *node, hostParams goa.Params, buf *goa.Params, raw bool) {
+	var ps goa.Params
+	if buf != nil && len(*buf) > 0 {
+		ps = *buf
+	}
+	if raw && r.UnescapePathValues {
+		for i := range ps {
+			if value, err := url.PathUnescape(ps[i].Value); err == nil {
+				ps[i].Value = value
 			}
 		}
 	}
+	if len(hostParams) > 0 {
+		ps = append(hostParams, ps...)
+	}
+	c.Params = ps
+	if v, ok := c.Get(mountScopeKey); ok && (r.SaveMatchedRoute || v.(mountScope).saveRoute) {
+		c.Set(MatchedRouteKey, v.(mountScope).prefix+leaf.fullPath)
+	} else if r.SaveMatchedRoute {
+		c.Set(MatchedRouteKey, leaf.fullPath)
+	}
+	leaf.handler(c)
+
+	if buf != nil {
+		c.Params = nil
+		r.putParams(buf)
+	}
+}
+
+// unhandled answers a request which could not be routed, either with the
+// allowed methods of the path or with NotFound.
+func (r *Router) unhandled(c *goa.Context) {
+	if !r.handleAllowed(c) && r.NotFound != nil {
+		r.NotFound(c)
+	}
+}
+
+// handleAllowed answers OPTIONS requests and requests with a method which is
+// not allowed for the path, if enabled. It returns false if the path has no
+// allowed methods.
+func (r *Router) handleAllowed(c *goa.Context) bool {
+	path, _ := r.routingPath(c)
 
 	if c.Method == "OPTIONS" && r.HandleOPTIONS {
 		// Handle OPTIONS requests
 		if allow := r.allowed(path, c.Method); len(allow) > 0 {
 			c.SetHeader("Allow", allow)
-			return
+			return true
 		}
 	} else {
 		// Handle 405
@@ -256,15 +653,11 @@ func (r *Router) Handle(c *goa.Context) {
 				} else {
 					c.Error(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 				}
-				return
+				return true
 			}
 		}
 	}
-
-	// Handle 404
-	if r.NotFound != nil {
-		r.NotFound(c)
-	}
+	return false
 }
 
 // Routes returns a goa.Middleware.
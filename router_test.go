@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -28,7 +29,7 @@ func TestParams(t *testing.T) {
 	}
 }
 
-func handle(c *goa.Context, req *http.Request, router Router) {
+func handle(c *goa.Context, req *http.Request, router *Router) {
 	c.Request = req
 	c.Method = req.Method
 	c.URL = req.URL
@@ -51,7 +52,7 @@ func TestRouter(t *testing.T) {
 	c := &goa.Context{}
 
 	req, _ := http.NewRequest("GET", "/user/gopher", nil)
-	handle(c, req, *router)
+	handle(c, req, router)
 
 	if !routed {
 		t.Fatal("routing failed")
@@ -90,49 +91,49 @@ func TestRouterAPI(t *testing.T) {
 	c := &goa.Context{}
 
 	r, _ := http.NewRequest("GET", "/GET", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !get {
 		t.Error("routing GET failed")
 	}
 
 	r, _ = http.NewRequest("HEAD", "/GET", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !head {
 		t.Error("routing HEAD failed")
 	}
 
 	r, _ = http.NewRequest("OPTIONS", "/GET", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !options {
 		t.Error("routing OPTIONS failed")
 	}
 
 	r, _ = http.NewRequest("POST", "/POST", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !post {
 		t.Error("routing POST failed")
 	}
 
 	r, _ = http.NewRequest("PUT", "/PUT", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !put {
 		t.Error("routing PUT failed")
 	}
 
 	r, _ = http.NewRequest("PATCH", "/PATCH", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !patch {
 		t.Error("routing PATCH failed")
 	}
 
 	r, _ = http.NewRequest("DELETE", "/DELETE", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !delete {
 		t.Error("routing DELETE failed")
 	}
 
 	r, _ = http.NewRequest("GET", "/Register", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !register {
 		t.Error("routing Register failed")
 	}
@@ -164,7 +165,7 @@ func TestRedirectTrailingSlash(t *testing.T) {
 	r, _ := http.NewRequest("GET", "/path/", nil)
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == 301 && strings.Contains(fmt.Sprint(w.Header()), "Location:[/path]")) {
 		t.Errorf("Redirect trailing slash failed with get method: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -174,7 +175,7 @@ func TestRedirectTrailingSlash(t *testing.T) {
 	r, _ = http.NewRequest("POST", "/path/", nil)
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == 307 && strings.Contains(fmt.Sprint(w.Header()), "Location:[/path]")) {
 		t.Errorf("Redirect trailing slash failed with post method: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -184,7 +185,7 @@ func TestRedirectTrailingSlash(t *testing.T) {
 	r, _ = http.NewRequest("PUT", "/path", nil)
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == 307 && strings.Contains(fmt.Sprint(w.Header()), "Location:[/path/]")) {
 		t.Errorf("Redirect trailing slash failed with redirecting /path to /path/: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -198,7 +199,7 @@ func TestRedirectFixedPath(t *testing.T) {
 	r, _ := http.NewRequest("GET", "/..//path", nil)
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == 301 && strings.Contains(fmt.Sprint(w.Header()), "Location:[/path]")) {
 		t.Errorf("Redirect fixed path failed: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -222,14 +223,14 @@ func TestRouterChaining(t *testing.T) {
 	c := &goa.Context{}
 
 	r, _ := http.NewRequest("POST", "/foo", nil)
-	handle(c, r, *router1)
+	handle(c, r, router1)
 	if !fooHit {
 		t.Errorf("Regular routing failed with router chaining.")
 		t.FailNow()
 	}
 
 	r, _ = http.NewRequest("POST", "/bar", nil)
-	handle(c, r, *router1)
+	handle(c, r, router1)
 	if !barHit {
 		t.Errorf("Chained routing failed with router chaining.")
 		t.FailNow()
@@ -248,7 +249,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	r, _ := http.NewRequest("OPTIONS", "*", nil)
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	} else if allow := w.Header().Get("Allow"); allow != "POST, OPTIONS" {
@@ -259,7 +260,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	r, _ = http.NewRequest("OPTIONS", "/path", nil)
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	} else if allow := w.Header().Get("Allow"); allow != "POST, OPTIONS" {
@@ -273,7 +274,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	// * (server)
 	r, _ = http.NewRequest("OPTIONS", "*", nil)
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	} else if allow := w.Header().Get("Allow"); allow != "POST, GET, OPTIONS" && allow != "GET, POST, OPTIONS" {
@@ -284,7 +285,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	r, _ = http.NewRequest("OPTIONS", "/path", nil)
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	} else if allow := w.Header().Get("Allow"); allow != "POST, GET, OPTIONS" && allow != "GET, POST, OPTIONS" {
@@ -301,7 +302,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	// * (server)
 	r, _ = http.NewRequest("OPTIONS", "*", nil)
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	} else if allow := w.Header().Get("Allow"); allow != "POST, GET, OPTIONS" && allow != "GET, POST, OPTIONS" {
@@ -315,7 +316,7 @@ func TestRouterOPTIONS(t *testing.T) {
 	r, _ = http.NewRequest("OPTIONS", "/path", nil)
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == http.StatusOK) {
 		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -336,7 +337,7 @@ func TestRouterNotAllowed(t *testing.T) {
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
 	recv := catchPanic(func() {
-		handle(c, r, *router)
+		handle(c, r, router)
 	})
 
 	if err, ok := recv.(goa.Error); !ok {
@@ -357,7 +358,7 @@ func TestRouterNotAllowed(t *testing.T) {
 	w = httptest.NewRecorder()
 	c.ResponseWriter = w
 	recv = catchPanic(func() {
-		handle(c, r, *router)
+		handle(c, r, router)
 	})
 
 	if err, ok := recv.(goa.Error); !ok {
@@ -375,7 +376,7 @@ func TestRouterNotAllowed(t *testing.T) {
 		customMethodNotAllowed = true
 	}
 
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !customMethodNotAllowed {
 		t.Error("coustom MethodNotAllowed handling failed")
 	}
@@ -396,7 +397,7 @@ func TestRouterNotFound(t *testing.T) {
 	}
 
 	r, _ := http.NewRequest("GET", "/nope", nil)
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(c.GetStatus() == 404 && notFound == true) {
 		t.Errorf("Custom NotFound handler failed: Code=%d", c.GetStatus())
 	}
@@ -406,7 +407,7 @@ func TestRouterNotFound(t *testing.T) {
 	r, _ = http.NewRequest("PATCH", "/path/", nil)
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !(w.Code == 307 && fmt.Sprint(w.Header()) == "map[Location:[/path]]") {
 		t.Errorf("Custom NotFound handler failed: Code=%d, Header=%v", w.Code, w.Header())
 	}
@@ -437,36 +438,851 @@ func TestRouterServeFiles(t *testing.T) {
 	r, _ := http.NewRequest("GET", "/favicon.ico", nil)
 	w := httptest.NewRecorder()
 	c.ResponseWriter = w
-	handle(c, r, *router)
+	handle(c, r, router)
 	if !mfs.opened {
 		t.Error("serving file failed")
 	}
 }
 
-// func TestRouteMiddleware(t *testing.T) {
-// 	c := &goa.Context{}
-// 	calls := []int{}
-// 	router := New()
-// 	router.GET("/", func(c *goa.Context) {
-// 	}, func(c *goa.Context, next func()) {
-// 		calls = append(calls, 1)
-// 		next()
-// 		calls = append(calls, 5)
-// 	}, func(c *goa.Context, next func()) {
-// 		calls = append(calls, 2)
-// 		next()
-// 		calls = append(calls, 4)
-// 	}, func(c *goa.Context, next func()) {
-// 		calls = append(calls, 3)
-// 		next()
-// 	})
-
-// 	r, _ := http.NewRequest("GET", "/", nil)
-// 	handle(c, r, *router)
-
-// 	for i, call := range calls {
-// 		if i+1 != call {
-// 			t.Error("Route use middleware fail")
-// 		}
-// 	}
-// }
+func TestRouteMiddleware(t *testing.T) {
+	c := &goa.Context{}
+	calls := []int{}
+	router := New()
+	router.GET("/", func(c *goa.Context) {
+	}, func(c *goa.Context, next func()) {
+		calls = append(calls, 1)
+		next()
+		calls = append(calls, 5)
+	}, func(c *goa.Context, next func()) {
+		calls = append(calls, 2)
+		next()
+		calls = append(calls, 4)
+	}, func(c *goa.Context, next func()) {
+		calls = append(calls, 3)
+		next()
+	})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	handle(c, r, router)
+
+	if len(calls) != 5 {
+		t.Fatalf("Route use middleware fail: calls=%v", calls)
+	}
+	for i, call := range calls {
+		if i+1 != call {
+			t.Error("Route use middleware fail")
+		}
+	}
+}
+
+func TestRouterConstraints(t *testing.T) {
+	c := &goa.Context{}
+	router := New()
+
+	var routed bool
+	router.GET("/users/:id<int>", func(c *goa.Context) {
+		routed = true
+	})
+
+	r, _ := http.NewRequest("GET", "/users/42", nil)
+	handle(c, r, router)
+	if !routed {
+		t.Fatal("routing constrained param failed")
+	}
+
+	// a value failing the constraint is not matched, neither for 405
+	var notFound bool
+	router.NotFound = func(c *goa.Context) {
+		notFound = true
+	}
+	r, _ = http.NewRequest("POST", "/users/abc", nil)
+	c.ResponseWriter = httptest.NewRecorder()
+	handle(c, r, router)
+	if !notFound {
+		t.Error("value failing the constraint was not answered with 404")
+	}
+
+	router.GET("/posts/:id<int>", func(c *goa.Context) {}).Name("post")
+	if url, err := router.URL("post", "id", "7"); err != nil || url != "/posts/7" {
+		t.Errorf("URL(\"post\") = %q, %v; want \"/posts/7\"", url, err)
+	}
+	if _, err := router.URL("post", "id", "x"); err == nil {
+		t.Error("URL with value failing the constraint did not fail")
+	}
+}
+
+func TestRouterStaticAndParam(t *testing.T) {
+	c := &goa.Context{}
+	router := New()
+
+	var route string
+	router.GET("/users/new", func(c *goa.Context) {
+		route = "new"
+	})
+	router.GET("/users/:id", func(c *goa.Context) {
+		route = "id:" + c.Param("id")
+	})
+
+	for path, want := range map[string]string{
+		"/users/new":   "new",
+		"/users/42":    "id:42",
+		"/users/newer": "id:newer",
+	} {
+		route = ""
+		r, _ := http.NewRequest("GET", path, nil)
+		handle(c, r, router)
+		if route != want {
+			t.Errorf("routing %s failed: want %q, got %q", path, want, route)
+		}
+	}
+}
+
+func TestRouterTryRegister(t *testing.T) {
+	h := func(c *goa.Context) {}
+	router := New()
+
+	if _, err := router.TryRegister("GET", "/users/:id/posts", h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// duplicate
+	_, err := router.TryRegister("GET", "/users/:id/posts", h)
+	if cerr, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected *ConflictError for duplicate route, got %#v", err)
+	} else if cerr.Path != "/users/:id/posts" || cerr.Existing != "/users/:id/posts" || cerr.Pos != 16 {
+		t.Errorf("wrong duplicate route error: %+v", cerr)
+	}
+
+	// duplicate of an optional part
+	router.GET("/posts/:page?", h)
+	_, err = router.TryRegister("GET", "/posts", h)
+	if cerr, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected *ConflictError for duplicate optional route, got %#v", err)
+	} else if cerr.Path != "/posts" || cerr.Existing != "/posts/:page?" || cerr.Pos != 6 {
+		t.Errorf("wrong duplicate optional route error: %+v", cerr)
+	} else if msg := cerr.Error(); msg != "a handler is already registered for path '/posts' by route '/posts/:page?'" {
+		t.Errorf("wrong duplicate optional route message: %s", msg)
+	}
+
+	// wildcard conflict
+	_, err = router.TryRegister("GET", "/users/:name", h)
+	if cerr, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected *ConflictError for wildcard conflict, got %#v", err)
+	} else if cerr.Path != "/users/:name" || cerr.Existing != "/users/:id/posts" || cerr.Pos != 7 {
+		t.Errorf("wrong wildcard conflict error: %+v", cerr)
+	} else if msg := cerr.Error(); msg != "':name' in new path '/users/:name' conflicts with existing wildcard ':id' in existing prefix '/users/:id'" {
+		t.Errorf("wrong wildcard conflict message: %s", msg)
+	}
+	_, err = router.TryRegister("GET", "/users(/:name)", h)
+	if cerr, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected *ConflictError for optional wildcard conflict, got %#v", err)
+	} else if cerr.Path != "/users(/:name)" || cerr.Pos != 8 {
+		t.Errorf("wrong optional wildcard conflict error: %+v", cerr)
+	} else if msg := cerr.Error(); msg != "':name' in new path '/users(/:name)' conflicts with existing wildcard ':id' in existing prefix '/users/:id'" {
+		t.Errorf("wrong optional wildcard conflict message: %s", msg)
+	}
+
+	// invalid patterns
+	for _, test := range []struct {
+		path string
+		pos  int
+	}{
+		{"", 0},
+		{"users", 0},
+		{"/users/:", 7},
+		{"/users/:id:name", 7},
+		{"/src/*filepath:x", 5},
+		{"/src*filepath", 4},
+		{"/users/:id<[a-z>", 10},
+		{"/items(/:id<[a-z>)", 11},
+		{"/items(/:)", 8},
+	} {
+		_, err := router.TryRegister("GET", test.path, h)
+		if perr, ok := err.(*InvalidPatternError); !ok {
+			t.Errorf("expected *InvalidPatternError for '%s', got %#v", test.path, err)
+		} else if perr.Path != test.path || perr.Pos != test.pos {
+			t.Errorf("wrong error for '%s': %+v", test.path, perr)
+		}
+	}
+
+	// rejected routes leave the router unchanged
+	if _, err := router.TryRegister("POST", "/users/:", h); err == nil {
+		t.Fatal("no error for invalid route")
+	}
+	if _, ok := router.load().trees["POST"]; ok {
+		t.Error("rejected route added a method tree")
+	}
+
+	if err := router.TryServeFiles("/noFilepath", &mockFileSystem{}); err == nil {
+		t.Error("no error for ServeFiles path not ending with '*filepath'")
+	} else if _, ok := err.(*InvalidPatternError); !ok {
+		t.Errorf("expected *InvalidPatternError, got %#v", err)
+	}
+
+	if _, err := router.Group("/api").TryRegister("GET", "users", h); err == nil {
+		t.Error("no error for group route not beginning with '/'")
+	}
+}
+
+func TestRouterMatchedRoute(t *testing.T) {
+	var inHandler, afterRoutes string
+
+	router := New()
+	router.SaveMatchedRoute = true
+	router.GET("/users/:id", func(c *goa.Context) {
+		inHandler = MatchedRoute(c)
+	})
+
+	app := goa.New()
+	app.Use(func(c *goa.Context) {
+		c.Next()
+		afterRoutes = MatchedRoute(c)
+	})
+	app.Use(router.Routes())
+
+	r, _ := http.NewRequest("GET", "/users/42", nil)
+	app.ServeHTTP(httptest.NewRecorder(), r)
+	if inHandler != "/users/:id" {
+		t.Errorf("wrong matched route in handler: %q", inHandler)
+	}
+	if afterRoutes != "/users/:id" {
+		t.Errorf("wrong matched route after Routes(): %q", afterRoutes)
+	}
+
+	r, _ = http.NewRequest("GET", "/nope", nil)
+	app.ServeHTTP(httptest.NewRecorder(), r)
+	if afterRoutes != "" {
+		t.Errorf("matched route for unmatched request: %q", afterRoutes)
+	}
+}
+
+func TestRouterRemove(t *testing.T) {
+	routed := ""
+	route := func(name string) Handler {
+		return func(c *goa.Context) {
+			routed = name
+			c.Status(http.StatusOK)
+		}
+	}
+
+	router := New()
+	router.GET("/users/:id", route("user")).Name("user")
+	router.GET("/users/new", route("new"))
+	router.POST("/users/:id", route("post user"))
+	router.DELETE("/users", route("delete users"))
+
+	if !router.Remove("GET", "/users/:id") {
+		t.Fatal("registered route was not removed")
+	}
+	if router.Remove("GET", "/users/:id") || router.Remove("PUT", "/users/:id") || router.Remove("GET", "/users/:name") {
+		t.Error("unregistered route was removed")
+	}
+	if _, err := router.URL("user"); err == nil {
+		t.Error("name of a removed route is still registered")
+	}
+
+	tests := []struct {
+		method, path, route string
+		code                int
+	}{
+		{"GET", "/users/42", "", http.StatusMethodNotAllowed},
+		{"GET", "/users/new", "new", http.StatusOK},
+		{"POST", "/users/42", "post user", http.StatusOK},
+	}
+	for _, test := range tests {
+		routed = ""
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if routed != test.route || w.Code != test.code {
+			t.Errorf("%s %s: routed to %q with %d, want %q with %d",
+				test.method, test.path, routed, w.Code, test.route, test.code)
+		}
+	}
+
+	// the route can be registered again
+	router.GET("/users/:id", route("user again")).Name("user")
+
+	// removing the last route of a method removes its tree
+	router.Remove("DELETE", "/users")
+	if _, ok := router.load().trees["DELETE"]; ok {
+		t.Error("empty tree was not removed")
+	}
+	if allow := router.allowed("*", ""); strings.Contains(allow, "DELETE") {
+		t.Errorf("removed method still allowed: %s", allow)
+	}
+}
+
+func TestRouterHEADFromGET(t *testing.T) {
+	router := New()
+	router.GET("/report", func(c *goa.Context) {
+		c.SetHeader("X-Report", "1")
+		c.String("hello")
+	})
+	router.GET("/raw", func(c *goa.Context) {
+		c.ResponseWriter.WriteHeader(http.StatusAccepted)
+		c.ResponseWriter.Write([]byte("raw body"))
+		c.Handled = true
+	})
+	router.GET("/both", func(c *goa.Context) { c.String("get") })
+	router.HEAD("/both", func(c *goa.Context) { c.SetHeader("X-Head", "1") })
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	allowed := func(w *httptest.ResponseRecorder) []string {
+		methods := strings.Split(w.Header().Get("Allow"), ", ")
+		sort.Strings(methods)
+		return methods
+	}
+
+	// disabled by default
+	if w := serve("HEAD", "/report"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("HEAD request served by GET route: %d", w.Code)
+	}
+
+	router.HandleHEADFromGET = true
+	w := serve("HEAD", "/report")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("wrong response: Code=%d, Body=%q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "5" || w.Header().Get("X-Report") != "1" {
+		t.Errorf("wrong headers: %v", w.Header())
+	}
+
+	w = serve("HEAD", "/raw")
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "8" {
+		t.Errorf("wrong response: Code=%d, Body=%q, Header=%v", w.Code, w.Body.String(), w.Header())
+	}
+
+	// HEAD routes take priority
+	if w = serve("HEAD", "/both"); w.Header().Get("X-Head") != "1" {
+		t.Error("HEAD route was not served")
+	}
+
+	if methods := allowed(serve("OPTIONS", "/report")); !reflect.DeepEqual(methods, []string{"GET", "HEAD", "OPTIONS"}) {
+		t.Errorf("wrong allowed methods for OPTIONS request: %v", methods)
+	}
+	w = serve("POST", "/report")
+	if methods := allowed(w); w.Code != http.StatusMethodNotAllowed ||
+		!reflect.DeepEqual(methods, []string{"GET", "HEAD", "OPTIONS"}) {
+		t.Errorf("wrong allowed methods for 405 response: %v", methods)
+	}
+	if methods := allowed(serve("OPTIONS", "/both")); !reflect.DeepEqual(methods, []string{"GET", "HEAD", "OPTIONS"}) {
+		t.Errorf("wrong allowed methods for OPTIONS request: %v", methods)
+	}
+	if methods := strings.Split(router.allowed("*", "OPTIONS"), ", "); len(methods) != 3 {
+		t.Errorf("wrong server-wide allowed methods: %v", methods)
+	}
+}
+
+func TestRouterRedirectPolicy(t *testing.T) {
+	router := New()
+	router.GET("/path", func(c *goa.Context) {})
+	router.POST("/path", func(c *goa.Context) {})
+	router.GET("/legacy", func(c *goa.Context) {}).Redirect(RedirectPolicy{GETCode: 302})
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	tests := []struct {
+		policy   *RedirectPolicy
+		method   string
+		url      string
+		code     int
+		location string
+	}{
+		{nil, "GET", "/path/?a=1", 301, "/path?a=1"},
+		{nil, "POST", "/path/", 307, "/path"},
+		{&RedirectPolicy{GETCode: 308, OtherCode: 308}, "GET", "/path/", 308, "/path"},
+		{&RedirectPolicy{GETCode: 308, OtherCode: 308}, "POST", "/PATH", 308, "/path"},
+		{&RedirectPolicy{DropQuery: true}, "GET", "/PATH?a=1", 301, "/path"},
+		// the policy of the route overrides the one of the router
+		{&RedirectPolicy{GETCode: 308}, "GET", "/legacy/", 302, "/legacy"},
+	}
+	for _, test := range tests {
+		router.RedirectPolicy = test.policy
+		w := serve(test.method, test.url)
+		if loc := w.Header().Get("Location"); w.Code != test.code || loc != test.location {
+			t.Errorf("%s %s: got %d to %q, want %d to %q", test.method, test.url, w.Code, loc, test.code, test.location)
+		}
+	}
+
+	var location string
+	var code int
+	router.RedirectPolicy = &RedirectPolicy{Handler: func(c *goa.Context, l string, redirectCode int) {
+		location, code = l, redirectCode
+		c.Status(http.StatusNoContent)
+	}}
+	if w := serve("GET", "/path/"); w.Code != http.StatusNoContent || location != "/path" || code != 301 {
+		t.Errorf("redirect handler not called correctly: Code=%d, location=%q, code=%d", w.Code, location, code)
+	}
+}
+
+func TestRouterServeInPlace(t *testing.T) {
+	var path string
+	var params goa.Params
+	router := New()
+	router.RedirectPolicy = &RedirectPolicy{ServeInPlace: true}
+	router.GET("/users/:name/posts", func(c *goa.Context) {
+		path, params = c.Path, c.Params
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		url, path, name string
+	}{
+		{"/users/Gopher/posts/", "/users/Gopher/posts", "Gopher"},
+		{"/USERS/Gopher/Posts", "/users/Gopher/posts", "Gopher"},
+		{"/users/../users/x//posts?a=1", "/users/x/posts", "x"},
+	}
+	for _, test := range tests {
+		path, params = "", nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: not served in place, Code=%d", test.url, w.Code)
+		}
+		if loc := w.Header().Get("Content-Location"); loc != test.path {
+			t.Errorf("%s: wrong Content-Location %q, want %q", test.url, loc, test.path)
+		}
+		if path != test.path {
+			t.Errorf("%s: handler saw path %q, want %q", test.url, path, test.path)
+		}
+		if want := (goa.Params{{Key: "name", Value: test.name}}); !reflect.DeepEqual(params, want) {
+			t.Errorf("%s: wrong params %v, want %v", test.url, params, want)
+		}
+	}
+}
+
+func TestRouterCaseInsensitive(t *testing.T) {
+	routed := ""
+	var params goa.Params
+	router := New()
+	router.CaseInsensitive = true
+	router.GET("/users/:id", func(c *goa.Context) { routed, params = "user", c.Params })
+	router.GET("/Users/Me", func(c *goa.Context) { routed, params = "me", c.Params })
+
+	tests := []struct {
+		path   string
+		route  string
+		params goa.Params
+	}{
+		{"/Users/Gopher", "user", goa.Params{{Key: "id", Value: "Gopher"}}},
+		{"/USERS/42", "user", goa.Params{{Key: "id", Value: "42"}}},
+		// exact matches take priority
+		{"/Users/Me", "me", nil},
+		{"/users/me", "user", goa.Params{{Key: "id", Value: "me"}}},
+		{"/uSeRs/x", "user", goa.Params{{Key: "id", Value: "x"}}},
+	}
+	for _, test := range tests {
+		routed, params = "", nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if routed != test.route || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: routed to %q with %v, want %q with %v", test.path, routed, params, test.route, test.params)
+		}
+		if w.Code == http.StatusMovedPermanently {
+			t.Errorf("%s: redirected", test.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/USERS/42", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong status for wrong method: %d", w.Code)
+	}
+}
+
+func TestRouterCaseInsensitiveRedirect(t *testing.T) {
+	var params goa.Params
+	router := New()
+	router.CaseInsensitive = true
+	router.RedirectPolicy = &RedirectPolicy{ServeInPlace: true}
+	router.GET("/users/:id", func(c *goa.Context) {
+		params = c.Params
+		c.Status(http.StatusOK)
+	})
+	router.GET("/posts", func(c *goa.Context) {}).Redirect(RedirectPolicy{GETCode: http.StatusPermanentRedirect})
+
+	// the trailing slash is fixed on the case-insensitive match
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/Users/42/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Location") != "/Users/42" {
+		t.Errorf("not served in place: Code=%d, Header=%v", w.Code, w.Header())
+	}
+	if want := (goa.Params{{Key: "id", Value: "42"}}); !reflect.DeepEqual(params, want) {
+		t.Errorf("wrong params %v, want %v", params, want)
+	}
+
+	// the policy of the route is found case-insensitively
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/Posts/", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/Posts" {
+		t.Errorf("route policy not applied: Code=%d, Header=%v", w.Code, w.Header())
+	}
+}
+
+func TestRouterCaseInsensitiveNonASCII(t *testing.T) {
+	for _, freeze := range []bool{false, true} {
+		router := New()
+		router.GET("/ä", func(c *goa.Context) {})
+		router.GET("/ö", func(c *goa.Context) {})
+		if freeze {
+			router.Freeze()
+		}
+
+		tests := []struct {
+			path     string
+			location string
+		}{
+			{"/%C3%84", "/%C3%A4"},
+			{"/%C3%96", "/%C3%B6"},
+			{"/%C3%9C", ""},
+		}
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+			if location := w.Header().Get("Location"); location != test.location {
+				t.Errorf("%s (frozen %v): redirected to %q, want %q", test.path, freeze, location, test.location)
+			}
+		}
+	}
+}
+
+func TestRouterUseRawPath(t *testing.T) {
+	routed := ""
+	var params goa.Params
+	record := func(name string) Handler {
+		return func(c *goa.Context) { routed, params = name, c.Params }
+	}
+
+	router := New()
+	router.UseRawPath = true
+	router.GET("/files/:name", record("file"))
+	router.GET("/files/:name/meta", record("meta"))
+	router.GET("/static/*filepath", record("static"))
+
+	tests := []struct {
+		path     string
+		unescape bool
+		route    string
+		params   goa.Params
+	}{
+		{"/files/a%2Fb", false, "file", goa.Params{{Key: "name", Value: "a%2Fb"}}},
+		{"/files/a%2Fb", true, "file", goa.Params{{Key: "name", Value: "a/b"}}},
+		{"/files/a%2Fb/meta", true, "meta", goa.Params{{Key: "name", Value: "a/b"}}},
+		{"/static/a%2Fb/c%20d", false, "static", goa.Params{{Key: "filepath", Value: "/a%2Fb/c%20d"}}},
+		{"/static/a%2Fb/c%20d", true, "static", goa.Params{{Key: "filepath", Value: "/a/b/c d"}}},
+		// without escaped slashes, the path is routed as usual
+		{"/files/a%20b", true, "file", goa.Params{{Key: "name", Value: "a b"}}},
+		{"/files/a/b", true, "", nil},
+	}
+	for _, test := range tests {
+		routed, params = "", nil
+		router.UnescapePathValues = test.unescape
+		req, _ := http.NewRequest("GET", test.path, nil)
+		handle(&goa.Context{}, req, router)
+		if routed != test.route || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s (unescape %v): routed to %q with %v, want %q with %v",
+				test.path, test.unescape, routed, params, test.route, test.params)
+		}
+	}
+
+	// the escaped path is kept in redirects
+	router.RedirectTrailingSlash = true
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/a%2Fb/meta/", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/files/a%2Fb/meta" {
+		t.Errorf("redirected with %d to %q, want 301 to \"/files/a%%2Fb/meta\"", w.Code, w.Header().Get("Location"))
+	}
+
+	// without UseRawPath, escaped slashes separate segments
+	router.UseRawPath = false
+	routed = ""
+	req, _ := http.NewRequest("GET", "/files/a%2Fb", nil)
+	handle(&goa.Context{}, req, router)
+	if routed != "" {
+		t.Errorf("routed to %q without UseRawPath", routed)
+	}
+}
+
+func TestRouterOptional(t *testing.T) {
+	routed := ""
+	var params goa.Params
+	record := func(name string) Handler {
+		return func(c *goa.Context) { routed, params = name, c.Params }
+	}
+
+	router := New()
+	router.GET("/posts/:page?", record("posts")).Name("posts")
+	router.GET("/archive(/:year(/:month))", record("archive")).Name("archive")
+	router.GET("/wiki/Go_(language)", record("wiki"))
+
+	tests := []struct {
+		path   string
+		route  string
+		params goa.Params
+	}{
+		{"/wiki/Go_(language)", "wiki", nil},
+		{"/wiki/Go_", "", nil},
+		{"/posts", "posts", nil},
+		{"/posts/2", "posts", goa.Params{{Key: "page", Value: "2"}}},
+		{"/archive", "archive", nil},
+		{"/archive/2020", "archive", goa.Params{{Key: "year", Value: "2020"}}},
+		{"/archive/2020/05", "archive", goa.Params{{Key: "year", Value: "2020"}, {Key: "month", Value: "05"}}},
+		{"/archive/2020/05/01", "", nil},
+	}
+	for _, test := range tests {
+		routed, params = "", nil
+		req, _ := http.NewRequest("GET", test.path, nil)
+		handle(&goa.Context{}, req, router)
+		if routed != test.route || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: routed to %q with %v, want %q with %v", test.path, routed, params, test.route, test.params)
+		}
+	}
+
+	urls := []struct {
+		name   string
+		params []string
+		url    string
+	}{
+		{"posts", nil, "/posts"},
+		{"posts", []string{"page", "3"}, "/posts/3"},
+		{"archive", nil, "/archive"},
+		{"archive", []string{"year", "2020"}, "/archive/2020"},
+		{"archive", []string{"year", "2020", "month", "05"}, "/archive/2020/05"},
+	}
+	for _, test := range urls {
+		if url, err := router.URL(test.name, test.params...); err != nil || url != test.url {
+			t.Errorf("URL(%q, %q) = %q, %v; want %q", test.name, test.params, url, err, test.url)
+		}
+	}
+	if _, err := router.URL("archive", "month", "05"); err == nil {
+		t.Error("no error for an optional param without its enclosing param")
+	}
+
+	// the paths of the optional parts conflict with registered routes
+	if _, err := router.TryRegister("GET", "/posts", record("other")); err == nil {
+		t.Error("no error for a path registered by an optional part")
+	}
+	_, err := router.TryRegister("GET", "/archive/:year?", record("other"))
+	if cerr, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected *ConflictError for conflicting optional parts, got %#v", err)
+	} else if cerr.Path != "/archive/:year?" || cerr.Existing != "/archive(/:year(/:month))" || cerr.Pos != 14 {
+		t.Errorf("wrong conflicting optional parts error: %+v", cerr)
+	}
+
+	// the paths of optional parts are not removed on their own
+	if router.Remove("GET", "/posts") || router.Remove("GET", "/archive/:year") {
+		t.Error("path of an optional part removed")
+	}
+	for _, path := range []string{"/posts", "/archive/2020"} {
+		routed = ""
+		req, _ := http.NewRequest("GET", path, nil)
+		handle(&goa.Context{}, req, router)
+		if routed == "" {
+			t.Errorf("%s: not routed after removing the path of an optional part", path)
+		}
+	}
+
+	if !router.Remove("GET", "/archive(/:year(/:month))") {
+		t.Fatal("route with optional parts not removed")
+	}
+	for _, path := range []string{"/archive", "/archive/2020", "/archive/2020/05"} {
+		routed = ""
+		req, _ := http.NewRequest("GET", path, nil)
+		handle(&goa.Context{}, req, router)
+		if routed != "" {
+			t.Errorf("%s: routed to %q after removal", path, routed)
+		}
+	}
+	if _, err := router.URL("archive"); err == nil {
+		t.Error("name of the removed route is still registered")
+	}
+
+	// an optional segment at the root expands to '/'
+	router = New()
+	router.GET("/:page?", record("root"))
+	for path, want := range map[string]goa.Params{"/": nil, "/2": {{Key: "page", Value: "2"}}} {
+		routed, params = "", nil
+		req, _ := http.NewRequest("GET", path, nil)
+		handle(&goa.Context{}, req, router)
+		if routed != "root" || !reflect.DeepEqual(params, want) {
+			t.Errorf("%s: routed to %q with %v, want %q with %v", path, routed, params, "root", want)
+		}
+	}
+}
+
+func TestRouterHandleMallocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping malloc count in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping malloc count with the race detector, which drops pooled values")
+	}
+
+	h := func(c *goa.Context) {}
+	router := New()
+	router.GET("/", h)
+	router.GET("/users", h)
+	router.GET("/users/:id", h)
+	router.GET("/users/:id/posts/:post", h)
+	router.GET("/src/*filepath", h)
+
+	for _, path := range []string{"/", "/users", "/users/42", "/users/42/posts/7", "/src/a/b.go"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		c := &goa.Context{}
+		handle(c, req, router)
+
+		allocs := testing.AllocsPerRun(100, func() { router.Handle(c) })
+		if allocs > 0 {
+			t.Errorf("Handle(%q): %v allocs, want zero", path, allocs)
+		}
+	}
+}
+
+// benchmarkRoutes is a route set resembling a REST API.
+var benchmarkRoutes = [...]string{
+	"/",
+	"/users",
+	"/users/:id",
+	"/users/:id/posts",
+	"/users/:id/posts/:post",
+	"/users/:id/followers",
+	"/users/:id/following",
+	"/users/:id/repos",
+	"/orgs",
+	"/orgs/:org",
+	"/orgs/:org/members",
+	"/orgs/:org/members/:user",
+	"/orgs/:org/teams",
+	"/repos/:owner/:repo",
+	"/repos/:owner/:repo/issues",
+	"/repos/:owner/:repo/issues/:number",
+	"/repos/:owner/:repo/pulls",
+	"/repos/:owner/:repo/pulls/:number",
+	"/search/repositories",
+	"/search/issues",
+	"/search/users",
+	"/gists",
+	"/gists/public",
+	"/gists/starred",
+	"/gists/:id",
+	"/notifications",
+	"/events",
+	"/feeds",
+	"/emojis",
+	"/rate_limit",
+	"/static/*filepath",
+}
+
+func benchmarkHandle(b *testing.B, path string, freeze bool) {
+	h := func(c *goa.Context) {}
+	router := New()
+	for _, route := range benchmarkRoutes {
+		router.GET(route, h)
+	}
+	if freeze {
+		router.Freeze()
+	}
+
+	req, _ := http.NewRequest("GET", path, nil)
+	c := &goa.Context{}
+	handle(c, req, router)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.Handle(c)
+	}
+}
+
+func BenchmarkRouterStatic(b *testing.B) {
+	benchmarkHandle(b, "/search/repositories", false)
+}
+
+func BenchmarkRouterParam(b *testing.B) {
+	benchmarkHandle(b, "/users/42", false)
+}
+
+func BenchmarkRouterParams(b *testing.B) {
+	benchmarkHandle(b, "/repos/goa-go/router/issues/7", false)
+}
+
+func TestRouterStaticRoutes(t *testing.T) {
+	routed := ""
+	record := func(name string) Handler {
+		return func(c *goa.Context) { routed = name }
+	}
+
+	router := New()
+	router.GET("/users", record("users"))
+	router.GET("/users/:id", record("user"))
+	router.GET("/posts/:page?", record("posts"))
+	router.POST("/users", record("create"))
+
+	static := router.load().static
+	for _, key := range []struct{ method, path string }{
+		{"GET", "/users"}, {"GET", "/posts"}, {"POST", "/users"},
+	} {
+		if static[key.method].get(key.path) == nil {
+			t.Errorf("%s %s is not indexed", key.method, key.path)
+		}
+	}
+	if n := len(static["GET"].leaves); n != 2 {
+		t.Errorf("%d static GET routes indexed, want 2", n)
+	}
+
+	// registering routes with wildcards keeps the index
+	router.GET("/users/:id/posts", record("user posts"))
+	if router.load().static["GET"] != static["GET"] {
+		t.Error("index rebuilt for a route with wildcards")
+	}
+
+	tests := []struct {
+		method, path string
+		route        string
+		code         int
+	}{
+		{"GET", "/users", "users", 0},
+		{"POST", "/users", "create", 0},
+		{"GET", "/users/new", "user", 0},
+		{"GET", "/posts", "posts", 0},
+		// trailing slash redirects are unaffected
+		{"GET", "/users/", "", http.StatusMovedPermanently},
+		{"PUT", "/users", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		routed = ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if routed != test.route || test.code != 0 && w.Code != test.code {
+			t.Errorf("%s %s: routed to %q with %d, want %q with %d", test.method, test.path, routed, w.Code, test.route, test.code)
+		}
+	}
+
+	// removed routes are removed from the index
+	router.Remove("GET", "/users")
+	router.Remove("GET", "/posts/:page?")
+	if index := router.load().static["GET"]; index.get("/users") != nil || len(index.leaves) != 0 {
+		t.Errorf("%d static GET routes indexed after removal, want 0", len(index.leaves))
+	}
+	routed = ""
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	if routed != "" || w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("removed route: routed to %q with %d", routed, w.Code)
+	}
+
+	// the published index is not modified by later registrations
+	router.GET("/users", record("users again"))
+	if static["GET"].get("/users").route == router.load().static["GET"].get("/users").route {
+		t.Error("index of a published table was modified")
+	}
+}
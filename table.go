@@ -0,0 +1,284 @@
+package router
+
+import (
+	"net/http"
+	"sync"
+)
+
+// table holds the routes of a router. Published tables are never modified,
+// writers publish a modified copy instead, so that requests can be routed
+// without locking.
+type table struct {
+	trees map[string]*node
+
+	// leaves of the routes without wildcards by method, which are matched
+	// without walking the trees
+	static map[string]*staticIndex
+
+	// named routes, see Route.Name
+	names routeNames
+
+	// mounted sub-routers and handlers, see Mount
+	mounts *node
+
+	// routers of exact hosts and host patterns, see Host
+	hosts        map[string]*Router
+	hostPatterns []*hostPattern
+
+	// set by Freeze, a frozen table is never updated
+	frozen bool
+}
+
+var emptyTable = &table{}
+
+// clone returns a copy of t which can be modified without affecting t.
+// Trees and names are persistent, so only the maps of the methods are copied.
+// The hosts are shared, they are copied when a host is added.
+func (t *table) clone() *table {
+	ct := &table{
+		trees:  make(map[string]*node, len(t.trees)),
+		static: make(map[string]*staticIndex, len(t.static)),
+		names:  t.names,
+		mounts: t.mounts,
+		hosts:  t.hosts,
+		// appending copies the patterns
+		hostPatterns: t.hostPatterns[:len(t.hostPatterns):len(t.hostPatterns)],
+	}
+	for method, root := range t.trees {
+		ct.trees[method] = root
+	}
+	for method, index := range t.static {
+		ct.static[method] = index
+	}
+	return ct
+}
+
+// routeNames maps the names of routes to the routes. It is persistent like
+// the trees, so that tables can share it: changes are collected in a small
+// map which is copied on each change, and merged into a new base map once it
+// outgrows the square root of the base. This way naming n routes takes
+// O(n√n) instead of O(n²) time.
+type routeNames struct {
+	base map[string]*Route
+	// the changes to base, removed names map to nil
+	recent map[string]*Route
+}
+
+// get returns the route with the given name, or nil.
+func (ns routeNames) get(name string) *Route {
+	if route, ok := ns.recent[name]; ok {
+		return route
+	}
+	return ns.base[name]
+}
+
+// set returns a copy of ns with name mapped to route, or removed if route is
+// nil.
+func (ns routeNames) set(name string, route *Route) routeNames {
+	recent := make(map[string]*Route, len(ns.recent)+1)
+	for name, route := range ns.recent {
+		recent[name] = route
+	}
+	recent[name] = route
+	if len(recent) <= 16 || len(recent)*len(recent) <= len(ns.base) {
+		return routeNames{base: ns.base, recent: recent}
+	}
+
+	base := make(map[string]*Route, len(ns.base)+len(recent))
+	for name, route := range ns.base {
+		base[name] = route
+	}
+	for name, route := range recent {
+		if route == nil {
+			delete(base, name)
+		} else {
+			base[name] = route
+		}
+	}
+	return routeNames{base: base}
+}
+
+// each calls fn for each named route.
+func (ns routeNames) each(fn func(route *Route)) {
+	for name, route := range ns.base {
+		if _, ok := ns.recent[name]; !ok {
+			fn(route)
+		}
+	}
+	for _, route := range ns.recent {
+		if route != nil {
+			fn(route)
+		}
+	}
+}
+
+// staticIndex maps the paths of the routes without wildcards of a tree to
+// their leaves. It is built from the tree on first use, so that registering
+// routes does not copy it.
+type staticIndex struct {
+	once sync.Once
+	// the tree the index is built from, nil once it is built
+	root *node
+
+	leaves map[string]*node
+}
+
+// get returns the leaf of the route with the given path, or nil.
+func (si *staticIndex) get(path string) *node {
+	si.once.Do(si.build)
+	return si.leaves[path]
+}
+
+func (si *staticIndex) build() {
+	si.leaves = make(map[string]*node)
+	si.root.walk(func(leaf *node) {
+		if countParams(leaf.fullPath) == 0 {
+			// a copy, so that the index does not keep the children of
+			// leaves copied by later inserts alive
+			si.leaves[leaf.fullPath] = &node{
+				handler:  leaf.handler,
+				fullPath: leaf.fullPath,
+				route:    leaf.route,
+			}
+		}
+	})
+	si.root = nil
+}
+
+// load returns the current table of the router.
+func (r *Router) load() *table {
+	if t, _ := r.table.Load().(*table); t != nil {
+		return t
+	}
+	return emptyTable
+}
+
+// update publishes a modified copy of the current table. The table is left
+// unchanged if fn returns an error, or if it is frozen in which case
+// ErrFrozen is returned.
+func (r *Router) update(fn func(t *table) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.load().frozen {
+		return ErrFrozen
+	}
+	t := r.load().clone()
+	if err := fn(t); err != nil {
+		return err
+	}
+	r.table.Store(t)
+	return nil
+}
+
+// Builder registers the routes of a new route table, see Router.Replace.
+type Builder struct {
+	router *Router
+}
+
+// GET is like Router.GET.
+func (b *Builder) GET(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.GET(path, handler, middleware...)
+}
+
+// HEAD is like Router.HEAD.
+func (b *Builder) HEAD(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.HEAD(path, handler, middleware...)
+}
+
+// OPTIONS is like Router.OPTIONS.
+func (b *Builder) OPTIONS(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.OPTIONS(path, handler, middleware...)
+}
+
+// POST is like Router.POST.
+func (b *Builder) POST(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.POST(path, handler, middleware...)
+}
+
+// PUT is like Router.PUT.
+func (b *Builder) PUT(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.PUT(path, handler, middleware...)
+}
+
+// PATCH is like Router.PATCH.
+func (b *Builder) PATCH(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.PATCH(path, handler, middleware...)
+}
+
+// DELETE is like Router.DELETE.
+func (b *Builder) DELETE(path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.DELETE(path, handler, middleware...)
+}
+
+// Register is like Router.Register.
+func (b *Builder) Register(method, path string, handler Handler, middleware ...Middleware) *Route {
+	return b.router.Register(method, path, handler, middleware...)
+}
+
+// TryRegister is like Router.TryRegister.
+func (b *Builder) TryRegister(method, path string, handler Handler, middleware ...Middleware) (*Route, error) {
+	return b.router.TryRegister(method, path, handler, middleware...)
+}
+
+// ServeFiles is like Router.ServeFiles.
+func (b *Builder) ServeFiles(path string, root http.FileSystem) {
+	b.router.ServeFiles(path, root)
+}
+
+// Group is like Router.Group.
+func (b *Builder) Group(prefix string, middleware ...Middleware) *Group {
+	return b.router.Group(prefix, middleware...)
+}
+
+// Mount is like Router.Mount.
+func (b *Builder) Mount(prefix string, sub *Router) {
+	b.router.Mount(prefix, sub)
+}
+
+// MountHandler is like Router.MountHandler.
+func (b *Builder) MountHandler(prefix string, h http.Handler) {
+	b.router.MountHandler(prefix, h)
+}
+
+// Host is like Router.Host.
+func (b *Builder) Host(pattern string) *Router {
+	return b.router.Host(pattern)
+}
+
+// Replace replaces all routes of the router by the routes registered by fn.
+// The new routes are published at once when fn returns, requests are routed
+// by either the old or the new routes, never by a mix of both. The router
+// options are kept.
+// Other registrations on r wait for Replace to finish, so fn must not
+// register routes on r directly. The builder and groups created with it must
+// not be used after fn returned. Replace panics if the router is frozen.
+//
+// router.Replace(func(b *router.Builder) { b.GET("/users", listUsers) })
+func (r *Router) Replace(fn func(b *Builder)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.load().frozen {
+		panic(ErrFrozen.Error())
+	}
+
+	staging := r.hostRouter()
+	fn(&Builder{staging})
+
+	t := staging.load()
+	for _, root := range t.trees {
+		root.walk(func(leaf *node) {
+			leaf.route.router = r
+		})
+	}
+	t.names.each(func(route *Route) {
+		route.router = r
+	})
+	if t.mounts != nil {
+		t.mounts.walk(func(leaf *node) {
+			leaf.route.router = r
+		})
+	}
+	r.table.Store(t)
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/goa-go/goa"
+)
+
+func TestRouterConcurrentRegister(t *testing.T) {
+	router := New()
+	router.GET("/", func(c *goa.Context) {})
+
+	const n = 50
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// route requests while routes are registered
+	var serving sync.WaitGroup
+	serving.Add(1)
+	go func() {
+		defer serving.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			req, _ := http.NewRequest("GET", "/items/"+strconv.Itoa(n/2), nil)
+			handle(&goa.Context{}, req, router)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			router.GET("/items/"+strconv.Itoa(i), func(c *goa.Context) {}).Name("item" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	serving.Wait()
+
+	if routes := router.List(); len(routes) != n+1 {
+		t.Errorf("%d routes registered, want %d", len(routes), n+1)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := router.URL("item" + strconv.Itoa(i)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRouterReplace(t *testing.T) {
+	var routed string
+	route := func(name string) Handler {
+		return func(c *goa.Context) { routed = name }
+	}
+
+	router := New()
+	router.GET("/old", route("old")).Name("old")
+	router.GET("/shared", route("old shared"))
+	router.NotFound = route("not found")
+
+	sub := New()
+	sub.GET("/users", route("sub users"))
+
+	router.Replace(func(b *Builder) {
+		b.GET("/shared", route("new shared"))
+		b.Group("/api").GET("/items/:id", route("new item")).Name("item")
+		b.Mount("/sub", sub)
+		b.Host("admin.example.com").GET("/shared", route("admin"))
+	})
+
+	tests := []struct {
+		host, path, route string
+	}{
+		{"", "/old", "not found"},
+		{"", "/shared", "new shared"},
+		{"", "/api/items/1", "new item"},
+		{"", "/sub/users", "sub users"},
+		{"", "/sub/posts", "not found"},
+		{"admin.example.com", "/shared", "admin"},
+		{"admin.example.com", "/old", "not found"},
+	}
+	for _, test := range tests {
+		routed = ""
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.Host = test.host
+		handle(&goa.Context{}, req, router)
+		if routed != test.route {
+			t.Errorf("%s%s: routed to %q, want %q", test.host, test.path, routed, test.route)
+		}
+	}
+
+	if _, err := router.URL("old"); err == nil {
+		t.Error("name of a replaced route is still registered")
+	}
+	if url, err := router.URL("item", "id", "1"); err != nil || url != "/api/items/1" {
+		t.Errorf("URL(\"item\") = %q, %v; want \"/api/items/1\"", url, err)
+	}
+
+	// routes registered by the builder belong to the router
+	router.GET("/later", route("later")).Name("later")
+	if recv := catchPanic(func() { router.GET("/dup", route("dup")).Name("item") }); recv == nil {
+		t.Error("name of a replacing route is not registered on the router")
+	}
+}
+
+func TestRouteNames(t *testing.T) {
+	routes := make([]*Route, 1000)
+	for i := range routes {
+		routes[i] = &Route{path: "/" + strconv.Itoa(i)}
+	}
+
+	var ns routeNames
+	versions := make([]routeNames, len(routes))
+	for i, route := range routes {
+		ns = ns.set(strconv.Itoa(i), route)
+		if i%2 == 1 {
+			ns = ns.set(strconv.Itoa(i-1), nil)
+		}
+		versions[i] = ns
+	}
+
+	// earlier versions are not affected by later changes
+	for i, ns := range versions {
+		for j, route := range routes {
+			var want *Route
+			if j <= i && (j%2 == 1 || j == i) {
+				want = route
+			}
+			if got := ns.get(strconv.Itoa(j)); got != want {
+				t.Fatalf("version %d: name %d maps to %v, want %v", i, j, got, want)
+			}
+		}
+	}
+
+	n := 0
+	ns.each(func(route *Route) {
+		if i, _ := strconv.Atoi(route.path[1:]); i%2 == 0 || ns.get(route.path[1:]) != route {
+			t.Errorf("each passed removed route %s", route.path)
+		}
+		n++
+	})
+	if n != len(routes)/2 {
+		t.Errorf("each passed %d routes, want %d", n, len(routes)/2)
+	}
+}
@@ -7,6 +7,7 @@ package router
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/goa-go/goa"
 )
@@ -20,6 +21,20 @@ const (
 	catchAll
 )
 
+// node is a node of the radix tree of a request method.
+//
+// The path of a static node is an edge of the tree, the path of a param node
+// is the wildcard with its constraint (":id<int>") and the path of a
+// catch-all node is the wildcard with the slash in front of it ("/*filepath").
+//
+// Param and catch-all nodes may have static children, which hold the text
+// following the wildcard, e.g. ".:ext" of /files/:name.:ext or "/blob" of
+// /repos/*path/blob.
+//
+// Static, param and catch-all children may share a parent. The children are
+// ordered by the priority of the lookup: the static children come first,
+// indexed by indices, followed by the param children, constrained ones before
+// the unconstrained one, and by the catch-all child.
 type node struct {
 	path      string
 	wildChild bool
@@ -29,6 +44,18 @@ type node struct {
 	children  []*node
 	handler   Handler
 	priority  uint32
+
+	// the full route path registered for this leaf, e.g. /users/:id
+	fullPath string
+
+	// the route registered for this leaf, set by the Router
+	route *Route
+
+	// constraint of a param node, nil if the param matches every value
+	constraint *constraint
+
+	// the static texts following a wildcard node, see delimiter
+	delims []delimiter
 }
 
 func min(a, b int) int {
@@ -45,6 +72,9 @@ func countParams(path string) uint8 {
 			continue
 		}
 		n++
+
+		// skip the wildcard name and constraint, which may contain '*'
+		i = wildcardEnd(path, i) - 1
 	}
 	if n >= 255 {
 		return 255
@@ -52,9 +82,31 @@ func countParams(path string) uint8 {
 	return uint8(n)
 }
 
-// increments priority of the given child and reorders if necessary
+// staticEnd returns the length of the static prefix of path, which ends at the
+// first param or at the slash in front of the first catch-all.
+func staticEnd(path string) int {
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case ':':
+			return i
+		case '/':
+			if i+1 < len(path) && path[i+1] == '*' {
+				return i
+			}
+		}
+	}
+	return len(path)
+}
+
+// clone returns a shallow copy of n owning its children slice.
+func (n *node) clone() *node {
+	cn := *n
+	cn.children = append([]*node(nil), n.children...)
+	return &cn
+}
+
+// reorders the static child at pos after its priority was incremented
 func (n *node) incrementChildPrio(pos int) int {
-	n.children[pos].priority++
 	prio := n.children[pos].priority
 
 	// adjust position (move to front)
@@ -76,498 +128,898 @@ func (n *node) incrementChildPrio(pos int) int {
 	return newPos
 }
 
-// addRoute adds a node with the given handler to the path.
-// Not concurrency-safe!
-func (n *node) addRoute(path string, handler Handler) *node {
-	fullPath := path
-	n.priority++
-	numParams := countParams(path)
-
-	// non-empty tree
-	if len(n.path) > 0 || len(n.children) > 0 {
-	walk:
-		for {
-			// Update maxParams of the current node
-			if numParams > n.maxParams {
-				n.maxParams = numParams
-			}
+// insertRoute adds a node with the given handler to the path, the handler is
+// wrapped by the given middleware once, at registration time.
+// The route is inserted into copies of the nodes along its path and n is left
+// unchanged. insertRoute returns the new root of the tree, which shares all
+// unchanged nodes with n, and the leaf of the route, or an
+// *InvalidPatternError or a *ConflictError if the route is rejected.
+func (n *node) insertRoute(path string, handler Handler, middleware ...Middleware) (*node, *node, error) {
+	numParams, err := checkPattern(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf := leafValue{compose(handler, middleware), path}
 
-			// Find the longest common prefix.
-			// This also implies that the common prefix contains no ':' or '*'
-			// since the existing key can't contain those chars.
-			i := 0
-			max := min(len(path), len(n.path))
-			for i < max && path[i] == n.path[i] {
-				i++
-			}
+	tree := n
+	// Empty tree
+	if len(n.path) == 0 && len(n.children) == 0 {
+		tree = &node{path: path[:staticEnd(path)], nType: root}
+	}
 
-			// Split edge
-			if i < len(n.path) {
-				child := node{
-					path:      n.path[i:],
-					wildChild: n.wildChild,
-					nType:     static,
-					indices:   n.indices,
-					children:  n.children,
-					handler:   n.handler,
-					priority:  n.priority - 1,
-				}
+	return tree.insert(path, path, numParams, leaf)
+}
 
-				// Update maxParams (max of all children)
-				for i := range child.children {
-					if child.children[i].maxParams > child.maxParams {
-						child.maxParams = child.children[i].maxParams
-					}
-				}
+// leafValue holds everything stored on a leaf node.
+type leafValue struct {
+	handler  Handler
+	fullPath string
+}
 
-				n.children = []*node{&child}
-				// []byte for proper unicode char conversion, see #65
-				n.indices = string([]byte{n.path[i]})
-				n.path = path[:i]
-				n.handler = nil
-				n.wildChild = false
-			}
+func (v leafValue) set(n *node) {
+	n.handler = v.handler
+	n.fullPath = v.fullPath
+	n.route = nil
+}
 
-			// Make new node a child of this node
-			if i < len(path) {
-				path = path[i:]
+// checkPattern validates the wildcards of the route path and returns the
+// number of params.
+func checkPattern(path string) (uint8, error) {
+	invalid := func(pos int, reason string) (uint8, error) {
+		return 0, &InvalidPatternError{Path: path, Pos: pos, Reason: reason}
+	}
 
-				if n.wildChild {
-					n = n.children[0]
-					n.priority++
+	if len(path) == 0 || path[0] != '/' {
+		return invalid(0, "path must begin with '/'")
+	}
 
-					// Update maxParams of the child node
-					if numParams > n.maxParams {
-						n.maxParams = numParams
-					}
-					numParams--
-
-					// Check if the wildcard matches
-					if len(path) >= len(n.path) && n.path == path[:len(n.path)] &&
-						// Check for longer wildcard, e.g. :name and :names
-						(len(n.path) >= len(path) || path[len(n.path)] == '/') {
-						continue walk
-					} else {
-						// Wildcard conflict
-						var pathSeg string
-						if n.nType == catchAll {
-							pathSeg = path
-						} else {
-							pathSeg = strings.SplitN(path, "/", 2)[0]
-						}
-						prefix := fullPath[:strings.Index(fullPath, pathSeg)] + n.path
-						panic("'" + pathSeg +
-							"' in new path '" + fullPath +
-							"' conflicts with existing wildcard '" + n.path +
-							"' in existing prefix '" + prefix +
-							"'")
-					}
-				}
+	for i, max := 0, len(path); i < max; i++ {
+		c := path[i]
+		if c != ':' && c != '*' {
+			continue
+		}
+
+		// find wildcard end, after its name and constraint
+		end := i + 1
+		var cons *constraint
+		for end < max && isNameByte(path[end]) {
+			end++
+		}
+		if end < max && path[end] == '<' {
+			var err error
+			if cons, err = parseConstraint(path, i, end); err != nil {
+				return 0, err
+			}
+			end = constraintEnd(path, end)
+		}
 
-				c := path[0]
+		// check if the wildcard has a name
+		if name, _ := splitWildcard(path[i:end]); len(name) == 0 {
+			return invalid(i, "wildcards must be named with a non-empty name")
+		}
 
-				// slash after param
-				if n.nType == param && c == '/' && len(n.children) == 1 {
-					n = n.children[0]
-					n.priority++
-					continue walk
-				}
+		// wildcards sharing a path segment must be separated by static text,
+		// which the value of the first one ends at
+		if end < max && (path[end] == ':' || path[end] == '*') {
+			return invalid(i, "wildcards in a path segment must be separated by static text, has: '"+
+				path[i:]+"'")
+		}
 
-				// Check if a child with the next path byte exists
-				for i := 0; i < len(n.indices); i++ {
-					if c == n.indices[i] {
-						i = n.incrementChildPrio(i)
-						n = n.children[i]
-						continue walk
-					}
-				}
+		if c == '*' { // catchAll
+			if cons != nil {
+				return invalid(i, "catch-all routes can not have a constraint")
+			}
 
-				// Otherwise insert it
-				if c != ':' && c != '*' {
-					// []byte for proper unicode char conversion, see #65
-					n.indices += string([]byte{c})
-					child := &node{
-						maxParams: numParams,
-					}
-					n.children = append(n.children, child)
-					n.incrementChildPrio(len(n.indices) - 1)
-					n = child
-				}
-				n.insertChild(numParams, path, fullPath, handler)
-				return n
+			// currently fixed width 1 for '/'
+			if path[i-1] != '/' {
+				return invalid(i, "no / before catch-all")
+			}
 
-			} else if i == len(path) { // Make node a (in-path) leaf
-				if n.handler != nil {
-					panic("a handler is already registered for path '" + fullPath + "'")
-				}
-				n.handler = handler
+			// the value of a catch-all ends at the static text following it,
+			// which must not be the '/' of another catch-all
+			if strings.HasPrefix(path[end:], "/*") {
+				return invalid(i, "catch-alls must be separated by static text, has: '"+
+					path[i:]+"'")
 			}
-			return n
 		}
-	} else { // Empty tree
-		n.insertChild(numParams, path, fullPath, handler)
-		n.nType = root
-		return n
+
+		i = end - 1
 	}
+
+	return countParams(path), nil
 }
 
-func (n *node) insertChild(numParams uint8, path, fullPath string, handler Handler) {
-	var offset int // already handled bytes of the path
+// insert returns a copy of n with the route added and the new leaf node.
+// path is the rest of the route path fullPath, starting at n, and numParams
+// is the number of params in path.
+func (n *node) insert(path, fullPath string, numParams uint8, leaf leafValue) (*node, *node, error) {
+	cn := n.clone()
+	cn.priority++
+	// Update maxParams of the current node
+	if numParams > cn.maxParams {
+		cn.maxParams = numParams
+	}
 
-	// find prefix until first wildcard (beginning with ':'' or '*'')
-	for i, max := 0, len(path); numParams > 0; i++ {
-		c := path[i]
-		if c != ':' && c != '*' {
-			continue
+	var rest string
+	switch n.nType {
+	case param, catchAll:
+		// the caller made sure the wildcard matches
+		rest = path[len(n.path):]
+		numParams--
+
+	default:
+		// Find the longest common prefix.
+		// This also implies that the common prefix contains no wildcard
+		// since the existing key can't contain those chars.
+		i := 0
+		max := min(staticEnd(path), len(n.path))
+		for i < max && path[i] == n.path[i] {
+			i++
 		}
 
-		// find wildcard end (either '/' or path end)
-		end := i + 1
-		for end < max && path[end] != '/' {
-			switch path[end] {
-			// the wildcard name must not contain ':' and '*'
-			case ':', '*':
-				panic("only one wildcard per path segment is allowed, has: '" +
-					path[i:] + "' in path '" + fullPath + "'")
-			default:
-				end++
+		// Split edge
+		if i < len(n.path) {
+			child := *n
+			child.path = n.path[i:]
+			child.nType = static
+
+			cn.path = n.path[:i]
+			cn.children = []*node{&child}
+			// []byte for proper unicode char conversion, see #65
+			cn.indices = string([]byte{n.path[i]})
+			cn.wildChild = false
+			leafValue{}.set(cn)
+		}
+		rest = path[i:]
+	}
+
+	// Make node a (in-path) leaf
+	if len(rest) == 0 {
+		if cn.handler != nil {
+			return nil, nil, &ConflictError{
+				Path:     fullPath,
+				Existing: cn.routePath(),
+				Pos:      len(fullPath),
 			}
 		}
+		leaf.set(cn)
+		return cn, cn, nil
+	}
 
-		// check if this Node existing children which would be
-		// unreachable if we insert the wildcard here
-		if len(n.children) > 0 {
-			panic("wildcard route '" + path[i:end] +
-				"' conflicts with existing children in path '" + fullPath + "'")
+	var (
+		pos   int   // position of the child to insert into
+		child *node // child to insert into
+	)
+	switch {
+	case rest[0] == ':' || strings.HasPrefix(rest, "/*"):
+		var err error
+		if pos, child, err = cn.wildcardChild(rest, fullPath); err != nil {
+			return nil, nil, err
 		}
 
-		// check if the wildcard has a name
-		if end-i < 2 {
-			panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
+	default:
+		// Check if a child with the next path byte exists
+		c := rest[0]
+		pos = strings.IndexByte(cn.indices, c)
+		if pos < 0 {
+			// Otherwise insert it
+			pos = len(cn.indices)
+			// []byte for proper unicode char conversion, see #65
+			cn.indices += string([]byte{c})
+			cn.children = append(cn.children, nil)
+			copy(cn.children[pos+1:], cn.children[pos:])
+			cn.children[pos] = &node{path: rest[:staticEnd(rest)]}
 		}
+		child = cn.children[pos]
+	}
 
-		if c == ':' { // param
-			// split path at the beginning of the wildcard
-			if i > 0 {
-				n.path = path[offset:i]
-				offset = i
-			}
+	child, ln, err := child.insert(rest, fullPath, numParams, leaf)
+	if err != nil {
+		return nil, nil, err
+	}
+	cn.children[pos] = child
+	if pos < len(cn.indices) {
+		cn.incrementChildPrio(pos)
+	}
+	if cn.nType == param || cn.nType == catchAll {
+		cn.delims = cn.delimiters()
+	}
+	return cn, ln, nil
+}
 
-			child := &node{
-				nType:     param,
-				maxParams: numParams,
-			}
-			n.children = []*node{child}
-			n.wildChild = true
-			n = child
-			n.priority++
-			numParams--
-
-			// if the path doesn't end with the wildcard, then there
-			// will be another non-wildcard subpath starting with '/'
-			if end < max {
-				n.path = path[offset:end]
-				offset = end
-
-				child := &node{
-					maxParams: numParams,
-					priority:  1,
-				}
-				n.children = []*node{child}
-				n = child
-			}
+// wildcardChild returns the wildcard child of n matching the wildcard at the
+// start of path and its position. A new child is added to n if none matches.
+func (n *node) wildcardChild(path, fullPath string) (int, *node, error) {
+	wildcard, nType := path[:wildcardEnd(path, 0)], param
+	if path[0] == '/' {
+		wildcard, nType = path[:wildcardEnd(path, 1)], catchAll
+	}
 
-		} else { // catchAll
-			if end != max || numParams > 1 {
-				panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
-			}
+	var cons *constraint
+	if _, pattern := splitWildcard(wildcard); nType == param && len(pattern) > 0 {
+		// the pattern was validated by checkPattern
+		cons, _ = newConstraint(pattern)
+	}
 
-			if len(n.path) > 0 && n.path[len(n.path)-1] == '/' {
-				panic("catch-all conflicts with existing handler for the path segment root in path '" + fullPath + "'")
-			}
+	pos := len(n.children)
+	for i := len(n.indices); i < len(n.children); i++ {
+		child := n.children[i]
+		if child.path == wildcard {
+			return i, child, nil
+		}
 
-			// currently fixed width 1 for '/'
-			i--
-			if path[i] != '/' {
-				panic("no / before catch-all in path '" + fullPath + "'")
+		// Wildcard conflict, both wildcards would match the same values
+		if child.nType == nType && (nType == catchAll ||
+			child.constraint.equal(cons)) {
+			prefix := fullPath[:len(fullPath)-len(path)]
+			return 0, nil, &ConflictError{
+				Path:             fullPath,
+				Existing:         child.anyRoute(prefix),
+				Pos:              len(prefix),
+				wildcard:         wildcard,
+				existingWildcard: child.path,
+				prefix:           prefix,
 			}
+		}
 
-			n.path = path[offset:i]
+		// constrained params are tried before the unconstrained one,
+		// which is tried before the catch-all
+		if pos == len(n.children) && (child.nType == catchAll ||
+			cons != nil && child.constraint == nil) {
+			pos = i
+		}
+	}
 
-			// first node: catchAll node with empty path
-			child := &node{
-				wildChild: true,
-				nType:     catchAll,
-				maxParams: 1,
-			}
-			n.children = []*node{child}
-			n.indices = string(path[i])
-			n = child
-			n.priority++
-
-			// second node: node holding the variable
-			child = &node{
-				path:      path[i:],
-				nType:     catchAll,
-				maxParams: 1,
-				handler:   handler,
-				priority:  1,
+	child := &node{
+		path:       wildcard,
+		nType:      nType,
+		constraint: cons,
+	}
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	n.wildChild = true
+	return pos, child, nil
+}
+
+// remove returns a copy of n without the route registered with path, which is
+// the rest of the route path starting at n, and the removed leaf. The copy is
+// nil if no route is left in the subtree of n. n is returned unchanged with a
+// nil leaf if the route is not registered.
+func (n *node) remove(path string) (*node, *node) {
+	if !strings.HasPrefix(path, n.path) {
+		return n, nil
+	}
+	rest := path[len(n.path):]
+
+	if len(rest) == 0 {
+		if n.handler == nil {
+			return n, nil
+		}
+		cn := n.clone()
+		leafValue{}.set(cn)
+		return cn.compact(), n
+	}
+
+	pos := -1
+	if rest[0] == ':' || strings.HasPrefix(rest, "/*") {
+		wildcard := rest[:wildcardEnd(rest, 0)]
+		if rest[0] == '/' {
+			wildcard = rest[:wildcardEnd(rest, 1)]
+		}
+		for i := len(n.indices); i < len(n.children); i++ {
+			if n.children[i].path == wildcard {
+				pos = i
+				break
 			}
-			n.children = []*node{child}
+		}
+	} else {
+		pos = strings.IndexByte(n.indices, rest[0])
+	}
+	if pos < 0 {
+		return n, nil
+	}
+
+	child, leaf := n.children[pos].remove(rest)
+	if leaf == nil {
+		return n, nil
+	}
 
-			return
+	cn := n.clone()
+	if child != nil {
+		cn.children[pos] = child
+	} else {
+		cn.children = append(cn.children[:pos], cn.children[pos+1:]...)
+		if pos < len(cn.indices) {
+			cn.indices = cn.indices[:pos] + cn.indices[pos+1:]
 		}
 	}
+	return cn.compact(), leaf
+}
+
+// compact restores the invariants of the copied node n after a route was
+// removed from its subtree. It returns nil if n has no route left, and
+// merges n with its only child if both are static and n is no leaf.
+func (n *node) compact() *node {
+	if n.handler == nil && len(n.children) == 0 {
+		return nil
+	}
+
+	if n.nType != param && n.nType != catchAll && n.handler == nil &&
+		len(n.children) == 1 && n.children[0].nType == static {
+		child := n.children[0].clone()
+		child.path = n.path + child.path
+		child.nType = n.nType
+		n = child
+	}
 
-	// insert remaining path part and handler to the leaf
-	n.path = path[offset:]
-	n.handler = handler
+	n.priority = 0
+	if n.handler != nil {
+		n.priority = 1
+	}
+	n.maxParams = 0
+	n.wildChild = false
+	for _, child := range n.children {
+		n.priority += child.priority
+		if child.maxParams > n.maxParams {
+			n.maxParams = child.maxParams
+		}
+		if child.nType == param || child.nType == catchAll {
+			n.wildChild = true
+		}
+	}
+	if n.nType == param || n.nType == catchAll {
+		n.maxParams++
+	}
+
+	// reorder the static children by priority
+	indices := []byte(n.indices)
+	for i := 1; i < len(indices); i++ {
+		for j := i; j > 0 && n.children[j-1].priority < n.children[j].priority; j-- {
+			n.children[j-1], n.children[j] = n.children[j], n.children[j-1]
+			indices[j-1], indices[j] = indices[j], indices[j-1]
+		}
+	}
+	n.indices = string(indices)
+	if n.nType == param || n.nType == catchAll {
+		n.delims = n.delimiters()
+	}
+
+	return n
 }
 
-// Returns the handler registered with the given path (key). The values of
-// wildcards are saved to a map.
-// If no handler can be found, a TSR (trailing slash redirect) recommendation is
-// made if a handler exists with an extra (without the) trailing slash for the
-// given path.
-func (n *node) getValue(path string) (handler Handler, p goa.Params, tsr bool) {
-walk: // outer loop for walking the tree
+// anyRoute returns the path of a route registered in the subtree of n.
+// prefix is the path in front of n.
+func (n *node) anyRoute(prefix string) string {
 	for {
-		if len(path) > len(n.path) {
-			if path[:len(n.path)] == n.path {
-				path = path[len(n.path):]
-				// If this node does not have a wildcard (param or catchAll)
-				// child,  we can just look up the next child node and continue
-				// to walk down the tree
-				if !n.wildChild {
-					c := path[0]
-					for i := 0; i < len(n.indices); i++ {
-						if c == n.indices[i] {
-							n = n.children[i]
-							continue walk
-						}
-					}
+		prefix += n.path
+		if n.handler != nil {
+			return n.routePath()
+		}
+		if len(n.children) == 0 {
+			return prefix
+		}
+		n = n.children[0]
+	}
+}
 
-					// Nothing found.
-					// We can recommend to redirect to the same URL without a
-					// trailing slash if a leaf exists for that path.
-					tsr = (path == "/" && n.handler != nil)
-					return
+// routePath returns the path the route of the leaf n was registered with,
+// which differs from its full path for expansions of optional parts.
+func (n *node) routePath() string {
+	if n.route != nil {
+		return n.route.path
+	}
+	return n.fullPath
+}
 
-				}
+// parseConstraint parses the constraint starting at path[i] == '<' of the
+// wildcard starting at path[start].
+func parseConstraint(path string, start, i int) (*constraint, error) {
+	end := constraintEnd(path, i)
+	if end < 0 {
+		return nil, &InvalidPatternError{Path: path, Pos: i,
+			Reason: "unterminated constraint in wildcard '" + path[start:] + "'"}
+	}
+	if end < len(path) && isNameByte(path[end]) {
+		return nil, &InvalidPatternError{Path: path, Pos: end,
+			Reason: "a constraint must end the wildcard '" + path[start:] + "'"}
+	}
 
-				// handler wildcard child
-				n = n.children[0]
-				switch n.nType {
-				case param:
-					// find param end (either '/' or path end)
-					end := 0
-					for end < len(path) && path[end] != '/' {
-						end++
-					}
+	cons, err := newConstraint(path[i+1 : end-1])
+	if err != nil {
+		return nil, &InvalidPatternError{Path: path, Pos: i,
+			Reason: "invalid constraint '" + path[i:end] + "' (" + err.Error() + ")"}
+	}
+	return cons, nil
+}
 
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(goa.Params, 0, n.maxParams)
-					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[1:]
-					p[i].Value = path[:end]
-
-					// we need to go deeper!
-					if end < len(path) {
-						if len(n.children) > 0 {
-							path = path[end:]
-							n = n.children[0]
-							continue walk
-						}
-
-						// ... but we can't
-						tsr = (len(path) == end+1)
-						return
-					}
+// paramName returns the name of a param or catch-all node.
+func (n *node) paramName() string {
+	if n.nType == catchAll {
+		return n.path[2:]
+	}
+	name, _ := splitWildcard(n.path)
+	return name
+}
 
-					if handler = n.handler; handler != nil {
-						return
-					} else if len(n.children) == 1 {
-						// No handler found. Check if a handler for this path + a
-						// trailing slash exists for TSR recommendation
-						n = n.children[0]
-						tsr = (n.path == "/" && n.handler != nil)
-					}
+// walk calls fn for every leaf of the subtree of n.
+func (n *node) walk(fn func(leaf *node)) {
+	if n.handler != nil {
+		fn(n)
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+// find returns the leaf matching path and appends the values of its wildcards
+// to p, unless p is nil. p is left unchanged if no leaf matches. If ci is set,
+// static paths are compared case-insensitively.
+func (n *node) find(path string, p *goa.Params, ci bool) (leaf *node, tsr bool) {
+	if leaf = n.match(path, p, ci, nil); leaf != nil {
+		return leaf, false
+	}
 
-					return
+	// Nothing found. We can recommend to redirect to the same URL with (without)
+	// a trailing slash if a leaf exists for that path.
+	if path, ok := toggleTrailingSlash(path); ok {
+		tsr = n.match(path, nil, ci, nil) != nil
+	}
+	return nil, tsr
+}
 
-				case catchAll:
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(goa.Params, 0, n.maxParams)
-					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[2:]
-					p[i].Value = path
+// toggleTrailingSlash removes the trailing slash of path or adds one.
+func toggleTrailingSlash(path string) (string, bool) {
+	if len(path) > 0 && path[len(path)-1] == '/' {
+		return path[:len(path)-1], len(path) > 1
+	}
+	return path + "/", true
+}
 
-					handler = n.handler
-					return
+// match returns the leaf of the subtree of n matching path, or nil.
+//
+// Children are tried in order, so static children take priority over params,
+// which take priority over catch-alls. If a child does not lead to a leaf, the
+// lookup backtracks and tries the next one.
+// If p is not nil, the values of the wildcards are appended to it. If ci is
+// set, static paths are compared case-insensitively. If buf is not nil, the
+// matched path, using the case of the static paths, is appended to it.
+func (n *node) match(path string, p *goa.Params, ci bool, buf *[]byte) *node {
+	var (
+		rest   string
+		pushed bool
+		bufLen int
+	)
+	if buf != nil {
+		bufLen = len(*buf)
+	}
 
-				default:
-					panic("invalid node type")
-				}
+	switch n.nType {
+	case static, root:
+		if !ci {
+			if len(path) < len(n.path) || path[:len(n.path)] != n.path {
+				return nil
 			}
-		} else if path == n.path {
-			// We should have reached the node containing the handler.
-			// Check if this node has a handler registered.
-			if handler = n.handler; handler != nil {
-				return
+			rest = path[len(n.path):]
+		} else {
+			i, j := foldPrefix(path, n.path)
+			if j < 0 {
+				return nil
 			}
-
-			if path == "/" && n.wildChild && n.nType != root {
-				tsr = true
-				return
+			if j < len(n.path) {
+				// the path of n ends within a rune, which is completed by
+				// the paths of the children
+				if buf != nil {
+					*buf = append(*buf, n.path...)
+				}
+				for _, rest := range foldSplitRune(path[i:], n.path[j:]) {
+					if leaf := n.matchChildren(rest, p, ci, buf); leaf != nil {
+						return leaf
+					}
+				}
+				if buf != nil {
+					*buf = (*buf)[:bufLen]
+				}
+				return nil
 			}
+			rest = path[i:]
+		}
+		if buf != nil {
+			*buf = append(*buf, n.path...)
+		}
 
-			// No handler found. Check if a handler for this path + a
-			// trailing slash exists for trailing slash recommendation
-			for i := 0; i < len(n.indices); i++ {
-				if n.indices[i] == '/' {
-					n = n.children[i]
-					tsr = (len(n.path) == 1 && n.handler != nil) ||
-						(n.nType == catchAll && n.children[0].handler != nil)
-					return
-				}
+	case param:
+		// find the end of the path segment
+		end := 0
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+
+		// a param followed by static text in its segment ends in front of
+		// the first occurrence of the text. Otherwise it ends the segment.
+		if leaf := n.matchDelimiters(path, end, p, ci, buf); leaf != nil {
+			return leaf
+		}
+
+		// params must not be empty and a value failing the constraint does
+		// not match
+		if end == 0 || n.constraint != nil && !n.constraint.match(path[:end]) {
+			return nil
+		}
+
+		if p != nil {
+			n.pushParam(p, path[:end])
+			pushed = true
+		}
+		if buf != nil {
+			*buf = append(*buf, path[:end]...)
+		}
+		rest = path[end:]
+
+	case catchAll:
+		// the catch-all value starts with the slash in front of it
+		if len(path) == 0 || path[0] != '/' {
+			return nil
+		}
+
+		// a catch-all followed by static text ends in front of the last
+		// occurrence of the text. Otherwise it ends the path.
+		if leaf := n.matchDelimiters(path, len(path), p, ci, buf); leaf != nil {
+			return leaf
+		}
+
+		if p != nil {
+			n.pushParam(p, path)
+			pushed = true
+		}
+		if buf != nil {
+			*buf = append(*buf, path...)
+		}
+
+	default:
+		panic("invalid node type")
+	}
+
+	if leaf := n.matchChildren(rest, p, ci, buf); leaf != nil {
+		return leaf
+	}
+
+	// Nothing found, backtrack
+	if pushed {
+		*p = (*p)[:len(*p)-1]
+	}
+	if buf != nil {
+		*buf = (*buf)[:bufLen]
+	}
+	return nil
+}
+
+// matchChildren returns n if rest is empty and n holds a handler, otherwise
+// the leaf of the children of n matching rest.
+func (n *node) matchChildren(rest string, p *goa.Params, ci bool, buf *[]byte) *node {
+	if len(rest) == 0 {
+		// We should have reached the node containing the handler.
+		// Check if this node has a handler registered.
+		if n.handler != nil {
+			return n
+		}
+		return nil
+	}
+
+	// Try the static child with the next path byte, then the wildcards
+	c := rest[0]
+	for i := 0; i < len(n.indices); i++ {
+		if c == n.indices[i] || ci && foldEqual(c, n.indices[i]) {
+			if leaf := n.children[i].match(rest, p, ci, buf); leaf != nil {
+				return leaf
 			}
+		}
+	}
+	for _, child := range n.children[len(n.indices):] {
+		if leaf := child.match(rest, p, ci, buf); leaf != nil {
+			return leaf
+		}
+	}
+	return nil
+}
 
-			return
+// delimiter is a static text which can follow a wildcard in a route, up to
+// the next wildcard or the end of the route, and for params up to the end of
+// the path segment. E.g. "." and "-v" follow :name in /files/:name.:ext and
+// /files/:name-v:version, "/blob/" follows *path in /repos/*path/blob/:ref.
+type delimiter struct {
+	text string
+	// position of the child of the wildcard node the text starts with
+	pos int
+}
+
+// delimiters returns the delimiters of the wildcard node n.
+func (n *node) delimiters() []delimiter {
+	var delims []delimiter
+	for pos, child := range n.children[:len(n.indices)] {
+		if n.nType == param && n.indices[pos] == '/' {
+			continue
 		}
+		delims = child.appendDelimiters(delims, "", pos, n.nType == param)
+	}
+	return delims
+}
 
-		// Nothing found. We can recommend to redirect to the same URL with an
-		// extra trailing slash if a leaf exists for that path
-		tsr = (path == "/") ||
-			(len(n.path) == len(path)+1 && n.path[len(path)] == '/' &&
-				path == n.path[:len(n.path)-1] && n.handler != nil)
-		return
+// appendDelimiters appends the delimiters starting with prefix followed by
+// the path of n to delims. If segment is set, they end at the next '/'.
+func (n *node) appendDelimiters(delims []delimiter, prefix string, pos int, segment bool) []delimiter {
+	text := prefix + n.path
+	if i := strings.IndexByte(text, '/'); segment && i >= 0 {
+		return appendDelimiter(delims, delimiter{text[:i], pos})
+	}
+	if n.handler != nil || len(n.children) > len(n.indices) {
+		delims = appendDelimiter(delims, delimiter{text, pos})
+	}
+	for _, child := range n.children[:len(n.indices)] {
+		delims = child.appendDelimiters(delims, text, pos, segment)
 	}
+	return delims
 }
 
-// Makes a case-insensitive lookup of the given path and tries to find a handler.
-// It can optionally also fix trailing slashes.
-// It returns the case-corrected path and a bool indicating whether the lookup
-// was successful.
-func (n *node) findCaseInsensitivePath(path string, fixTrailingSlash bool) (ciPath []byte, found bool) {
-	ciPath = make([]byte, 0, len(path)+1) // preallocate enough memory
+// appendDelimiter appends d to delims unless its text is already in delims.
+func appendDelimiter(delims []delimiter, d delimiter) []delimiter {
+	for _, other := range delims {
+		if other.text == d.text {
+			return delims
+		}
+	}
+	return append(delims, d)
+}
 
-	// Outer loop for walking the tree
-	for len(path) >= len(n.path) && strings.EqualFold(path[:len(n.path)], n.path) {
-		path = path[len(n.path):]
-		ciPath = append(ciPath, n.path...)
-
-		if len(path) > 0 {
-			// If this node does not have a wildcard (param or catchAll) child,
-			// we can just look up the next child node and continue to walk down
-			// the tree
-			if !n.wildChild {
-				r := unicode.ToLower(rune(path[0]))
-				for i, index := range n.indices {
-					// must use recursive approach since both index and
-					// ToLower(index) could exist. We must check both.
-					if r == unicode.ToLower(index) {
-						out, found := n.children[i].findCaseInsensitivePath(path, fixTrailingSlash)
-						if found {
-							return append(ciPath, out...), true
-						}
-					}
-				}
+// indexDelimiters returns the positions of the delimiters in path[1:end],
+// where a wildcard value ending in front of them is not empty. The first
+// occurrences are returned, or the last ones if last is set. Positions are -1
+// for delimiters which do not occur. buf is used if it is large enough.
+func indexDelimiters(delims []delimiter, path string, end int, last, ci bool, buf []int) []int {
+	positions := buf[:0]
+	for _, d := range delims {
+		i := -1
+		switch {
+		case end <= 1:
+		case !ci && last:
+			i = strings.LastIndex(path[1:end], d.text)
+		case !ci:
+			i = strings.Index(path[1:end], d.text)
+		default:
+			i = indexFold(path[1:end], d.text, last)
+		}
+		if i >= 0 {
+			i++
+		}
+		positions = append(positions, i)
+	}
+	return positions
+}
 
-				// Nothing found. We can recommend to redirect to the same URL
-				// without a trailing slash if a leaf exists for that path
-				found = fixTrailingSlash && path == "/" && n.handler != nil
-				return
-			}
+// indexFold is like strings.Index or, if last is set, strings.LastIndex but
+// compares under Unicode case folding.
+func indexFold(s, substr string, last bool) int {
+	for k := 0; k < len(s); k++ {
+		i := k
+		if last {
+			i = len(s) - 1 - k
+		}
+		if _, j := foldPrefix(s[i:], substr); j == len(substr) {
+			return i
+		}
+	}
+	return -1
+}
 
-			n = n.children[0]
-			switch n.nType {
-			case param:
-				// find param end (either '/' or path end)
-				k := 0
-				for k < len(path) && path[k] != '/' {
-					k++
-				}
+// nextDelimiter returns the index of the delimiter to try next and its
+// position, and marks it as tried. Delimiters are tried in the order of their
+// positions, ascending or descending if last is set, longer texts first at
+// the same position. It returns -1 if all delimiters were tried.
+func nextDelimiter(delims []delimiter, positions []int, last bool) (int, int) {
+	next := -1
+	for j, i := range positions {
+		if i < 0 {
+			continue
+		}
+		if next < 0 {
+			next = j
+		} else if k := positions[next]; i == k && len(delims[j].text) > len(delims[next].text) || i != k && (i < k) != last {
+			next = j
+		}
+	}
+	if next < 0 {
+		return -1, -1
+	}
+	i := positions[next]
+	positions[next] = -1
+	return next, i
+}
 
-				// add param value to case insensitive path
-				ciPath = append(ciPath, path[:k]...)
+// matchDelimiters matches the wildcard node n with values ending in front of
+// one of its delimiters in path[:end], see node.match. Each delimiter is
+// tried once, at its first occurrence for params and at its last one for
+// catch-alls, so that the time taken is linear in the length of path.
+func (n *node) matchDelimiters(path string, end int, p *goa.Params, ci bool, buf *[]byte) *node {
+	if len(n.delims) == 0 {
+		return nil
+	}
 
-				// we need to go deeper!
-				if k < len(path) {
-					if len(n.children) > 0 {
-						path = path[k:]
-						n = n.children[0]
-						continue
-					}
+	last := n.nType == catchAll
+	var positionsBuf [8]int
+	positions := indexDelimiters(n.delims, path, end, last, ci, positionsBuf[:])
+	for {
+		j, i := nextDelimiter(n.delims, positions, last)
+		if j < 0 {
+			return nil
+		}
+		if leaf := n.matchDelimited(path, i, n.delims[j].pos, p, ci, buf); leaf != nil {
+			return leaf
+		}
+	}
+}
 
-					// ... but we can't
-					if fixTrailingSlash && len(path) == k+1 {
-						return ciPath, true
-					}
-					return
-				}
+// matchDelimited matches the wildcard node n with the value path[:end], which
+// is followed by the static text of its child at pos.
+func (n *node) matchDelimited(path string, end, pos int, p *goa.Params, ci bool, buf *[]byte) *node {
+	if n.constraint != nil && !n.constraint.match(path[:end]) {
+		return nil
+	}
 
-				if n.handler != nil {
-					return ciPath, true
-				} else if fixTrailingSlash && len(n.children) == 1 {
-					// No handle found. Check if a handle for this path + a
-					// trailing slash exists
-					n = n.children[0]
-					if n.path == "/" && n.handler != nil {
-						return append(ciPath, '/'), true
-					}
-				}
-				return
+	var bufLen int
+	if p != nil {
+		n.pushParam(p, path[:end])
+	}
+	if buf != nil {
+		bufLen = len(*buf)
+		*buf = append(*buf, path[:end]...)
+	}
+
+	if leaf := n.children[pos].match(path[end:], p, ci, buf); leaf != nil {
+		return leaf
+	}
 
-			case catchAll:
-				return append(ciPath, path...), true
+	// backtrack
+	if p != nil {
+		*p = (*p)[:len(*p)-1]
+	}
+	if buf != nil {
+		*buf = (*buf)[:bufLen]
+	}
+	return nil
+}
+
+// pushParam appends the value of the wildcard node n to p.
+func (n *node) pushParam(p *goa.Params, value string) {
+	if *p == nil {
+		// lazy allocation
+		*p = make(goa.Params, 0, n.maxParams)
+	}
+	*p = append(*p, goa.Param{Key: n.paramName(), Value: value})
+}
+
+// foldEqual reports whether the first bytes a and b of two paths may be equal
+// under case folding. ASCII letters are compared ignoring their case. Bytes
+// starting non-ASCII runes are candidates for each other and for ASCII bytes,
+// as e.g. the Kelvin sign folds to 'k', their runes are compared by
+// foldPrefix. Other bytes must be equal.
+func foldEqual(a, b byte) bool {
+	if a < utf8.RuneSelf && b < utf8.RuneSelf {
+		return a == b || toLower(a) == toLower(b)
+	}
+	return a == b || utf8.RuneStart(a) && utf8.RuneStart(b)
+}
 
-			default:
-				panic("invalid node type")
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c
+}
+
+// foldPrefix compares the static path prefix with the start of path under
+// Unicode case folding, rune by rune. It returns the lengths i of the start of
+// path and j of prefix which are equal, j is -1 if they differ.
+//
+// The path of a node may end within a multi-byte rune, which is completed by
+// the paths of its children. j is less than len(prefix) then and prefix[j:]
+// holds the first bytes of the rune, see foldSplitRune. Paths starting with
+// the remaining bytes of a rune are compared byte-wise.
+func foldPrefix(path, prefix string) (i, j int) {
+	for j < len(prefix) {
+		if i >= len(path) {
+			return 0, -1
+		}
+		a, b := prefix[j], path[i]
+		switch {
+		case a < utf8.RuneSelf && b < utf8.RuneSelf:
+			if a != b && toLower(a) != toLower(b) {
+				return 0, -1
 			}
-		} else {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if n.handler != nil {
-				return ciPath, true
+			i++
+			j++
+		case !utf8.RuneStart(a):
+			if a != b {
+				return 0, -1
 			}
-
-			// No handle found.
-			// Try to fix the path by adding a trailing slash
-			if fixTrailingSlash {
-				for i := 0; i < len(n.indices); i++ {
-					if n.indices[i] == '/' {
-						n = n.children[i]
-						if (len(n.path) == 1 && n.handler != nil) ||
-							(n.nType == catchAll && n.children[0].handler != nil) {
-							return append(ciPath, '/'), true
-						}
-						return
-					}
+			i++
+			j++
+		case !utf8.FullRuneInString(prefix[j:]):
+			return i, j
+		default:
+			ra, na := utf8.DecodeRuneInString(prefix[j:])
+			rb, nb := utf8.DecodeRuneInString(path[i:])
+			if ra == utf8.RuneError && na == 1 || rb == utf8.RuneError && nb == 1 {
+				// invalid UTF-8 is compared byte-wise
+				if a != b {
+					return 0, -1
 				}
+				na, nb = 1, 1
+			} else if !equalFoldRune(ra, rb) {
+				return 0, -1
 			}
-			return
+			i += nb
+			j += na
+		}
+	}
+	return i, j
+}
+
+// foldSplitRune returns the paths to match against the children of a node
+// whose path ends with part, the first bytes of a multi-byte rune: for each
+// case variant of the first rune of path which starts with part, the
+// remaining bytes of the variant followed by the rest of path.
+func foldSplitRune(path, part string) []string {
+	r, size := utf8.DecodeRuneInString(path)
+	if r == utf8.RuneError && size <= 1 {
+		return nil
+	}
+
+	var rests []string
+	var enc [utf8.UTFMax]byte
+	v := r
+	for {
+		if l := utf8.EncodeRune(enc[:], v); l > len(part) && string(enc[:len(part)]) == part {
+			rests = append(rests, string(enc[len(part):l])+path[size:])
+		}
+		if v = unicode.SimpleFold(v); v == r {
+			return rests
+		}
+	}
+}
+
+// equalFoldRune reports whether the runes a and b are equal under simple
+// Unicode case folding.
+func equalFoldRune(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
+			return true
 		}
 	}
+	return false
+}
+
+// Makes a case-insensitive lookup of the given path and tries to find a handler.
+// It can optionally also fix trailing slashes.
+// It returns the case-corrected path and a bool indicating whether the lookup
+// was successful.
+func (n *node) findCaseInsensitivePath(path string, fixTrailingSlash bool) (ciPath []byte, found bool) {
+	ciPath = make([]byte, 0, len(path)+1) // preallocate enough memory
+
+	if n.match(path, nil, true, &ciPath) != nil {
+		return ciPath, true
+	}
 
 	// Nothing found.
 	// Try to fix the path by adding / removing a trailing slash
 	if fixTrailingSlash {
-		if path == "/" {
-			return ciPath, true
-		}
-		if len(path)+1 == len(n.path) && n.path[len(path)] == '/' &&
-			strings.EqualFold(path, n.path[:len(path)]) &&
-			n.handler != nil {
-			return append(ciPath, n.path...), true
+		if path, ok := toggleTrailingSlash(path); ok {
+			if n.match(path, nil, true, &ciPath) != nil {
+				return ciPath, true
+			}
 		}
 	}
-	return
+	return nil, false
 }
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/goa-go/goa"
 )
@@ -33,6 +35,37 @@ func fakeHandler(val string) Handler {
 	}
 }
 
+// addRoute inserts the route into the tree and replaces *tree with the new
+// root. It returns the leaf of the route and panics if the route is rejected.
+func addRoute(tree **node, path string, handler Handler) *node {
+	root, leaf, err := (*tree).insertRoute(path, handler)
+	if err != nil {
+		panic(err.Error())
+	}
+	*tree = root
+	return leaf
+}
+
+// getValue returns the handler registered with the given path and the values
+// of its wildcards. If no handler can be found, a TSR (trailing slash
+// redirect) recommendation is made if a handler exists with an extra (without
+// the) trailing slash for the given path.
+func (n *node) getValue(path string) (handler Handler, p goa.Params, tsr bool) {
+	leaf, p, tsr := n.lookup(path)
+	if leaf != nil {
+		handler = leaf.handler
+	}
+	return
+}
+
+// lookup is like getValue but returns the leaf node holding the handler.
+func (n *node) lookup(path string) (leaf *node, p goa.Params, tsr bool) {
+	if leaf, tsr = n.find(path, &p, false); leaf == nil {
+		return nil, nil, tsr
+	}
+	return leaf, p, false
+}
+
 type testRequests []struct {
 	path       string
 	nilHandler bool
@@ -91,7 +124,7 @@ func checkMaxParams(t *testing.T, n *node) uint8 {
 			maxParams = Params
 		}
 	}
-	if n.nType > root && !n.wildChild {
+	if n.nType == param || n.nType == catchAll {
 		maxParams++
 	}
 
@@ -131,7 +164,7 @@ func TestTreeAddAndGet(t *testing.T) {
 		"/β",
 	}
 	for _, route := range routes {
-		tree.addRoute(route, fakeHandler(route))
+		addRoute(&tree, route, fakeHandler(route))
 	}
 
 	checkRequests(t, tree, testRequests{
@@ -172,19 +205,19 @@ func TestTreeWildcard(t *testing.T) {
 		"/info/:user/project/:project",
 	}
 	for _, route := range routes {
-		tree.addRoute(route, fakeHandler(route))
+		addRoute(&tree, route, fakeHandler(route))
 	}
 
 	checkRequests(t, tree, testRequests{
 		{"/", false, "/", nil},
 		{"/cmd/test/", false, "/cmd/:tool/", goa.Params{goa.Param{"tool", "test"}}},
-		{"/cmd/test", true, "", goa.Params{goa.Param{"tool", "test"}}},
+		{"/cmd/test", true, "", nil},
 		{"/cmd/test/3", false, "/cmd/:tool/:sub", goa.Params{goa.Param{"tool", "test"}, goa.Param{"sub", "3"}}},
 		{"/src/", false, "/src/*filepath", goa.Params{goa.Param{"filepath", "/"}}},
 		{"/src/some/file.png", false, "/src/*filepath", goa.Params{goa.Param{"filepath", "/some/file.png"}}},
 		{"/search/", false, "/search/", nil},
 		{"/search/someth!ng+in+ünìcodé", false, "/search/:query", goa.Params{goa.Param{"query", "someth!ng+in+ünìcodé"}}},
-		{"/search/someth!ng+in+ünìcodé/", true, "", goa.Params{goa.Param{"query", "someth!ng+in+ünìcodé"}}},
+		{"/search/someth!ng+in+ünìcodé/", true, "", nil},
 		{"/user_gopher", false, "/user_:name", goa.Params{goa.Param{"name", "gopher"}}},
 		{"/user_gopher/about", false, "/user_:name/about", goa.Params{goa.Param{"name", "gopher"}}},
 		{"/files/js/inc/framework.js", false, "/files/:dir/*filepath", goa.Params{goa.Param{"dir", "js"}, goa.Param{"filepath", "/inc/framework.js"}}},
@@ -215,7 +248,7 @@ func testRoutes(t *testing.T, routes []testRoute) {
 
 	for _, route := range routes {
 		recv := catchPanic(func() {
-			tree.addRoute(route.path, nil)
+			addRoute(&tree, route.path, nil)
 		})
 
 		if route.conflict {
@@ -232,20 +265,27 @@ func testRoutes(t *testing.T, routes []testRoute) {
 func TestTreeWildcardConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/:tool/:sub", false},
-		{"/cmd/vet", true},
+		{"/cmd/vet", false},
+		{"/cmd/:tool/vet", false},
+		{"/cmd/:other", true},
+		{"/cmd/:tool/:other", true},
 		{"/src/*filepath", false},
 		{"/src/*filepathx", true},
-		{"/src/", true},
+		{"/src/", false},
+		{"/src/:file", false},
 		{"/src1/", false},
-		{"/src1/*filepath", true},
+		{"/src1/*filepath", false},
 		{"/src2*filepath", true},
 		{"/search/:query", false},
-		{"/search/invalid", true},
+		{"/search/invalid", false},
 		{"/user_:name", false},
-		{"/user_x", true},
+		{"/user_x", false},
 		{"/user_:name", false},
+		{"/user_:id", true},
 		{"/id:id", false},
-		{"/id/:id", true},
+		{"/id/:id", false},
+		{"/id/:id<int>", false},
+		{"/id/:num<int>", true},
 	}
 	testRoutes(t, routes)
 }
@@ -253,19 +293,69 @@ func TestTreeWildcardConflict(t *testing.T) {
 func TestTreeChildConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/vet", false},
-		{"/cmd/:tool/:sub", true},
+		{"/cmd/:tool/:sub", false},
 		{"/src/AUTHORS", false},
-		{"/src/*filepath", true},
+		{"/src/*filepath", false},
 		{"/user_x", false},
-		{"/user_:name", true},
+		{"/user_:name", false},
 		{"/id/:id", false},
-		{"/id:id", true},
-		{"/:id", true},
-		{"/*filepath", true},
+		{"/id:id", false},
+		{"/:id", false},
+		{"/*filepath", false},
+		{"/*other", true},
 	}
 	testRoutes(t, routes)
 }
 
+func TestTreeSharedParent(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/users/new",
+		"/users/:id",
+		"/users/:id<int>",
+		"/users/:id/edit",
+		"/users/new/edit",
+		"/users/*path",
+		"/src/AUTHORS",
+		"/src/*filepath",
+		"/:page",
+		"/*filepath",
+		"/about",
+	}
+	for _, route := range routes {
+		recv := catchPanic(func() {
+			addRoute(&tree, route, fakeHandler(route))
+		})
+		if recv != nil {
+			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		}
+	}
+
+	checkRequests(t, tree, testRequests{
+		{"/users/new", false, "/users/new", nil},
+		{"/users/newer", false, "/users/:id", goa.Params{goa.Param{Key: "id", Value: "newer"}}},
+		{"/users/42", false, "/users/:id<int>", goa.Params{goa.Param{Key: "id", Value: "42"}}},
+		{"/users/gopher", false, "/users/:id", goa.Params{goa.Param{Key: "id", Value: "gopher"}}},
+		{"/users/new/edit", false, "/users/new/edit", nil},
+		{"/users/42/edit", false, "/users/:id/edit", goa.Params{goa.Param{Key: "id", Value: "42"}}},
+		// backtracking from the static and the param child
+		{"/users/new/delete", false, "/users/*path", goa.Params{goa.Param{Key: "path", Value: "/new/delete"}}},
+		{"/users/42/delete", false, "/users/*path", goa.Params{goa.Param{Key: "path", Value: "/42/delete"}}},
+		{"/users/", false, "/users/*path", goa.Params{goa.Param{Key: "path", Value: "/"}}},
+		{"/src/AUTHORS", false, "/src/AUTHORS", nil},
+		{"/src/LICENSE", false, "/src/*filepath", goa.Params{goa.Param{Key: "filepath", Value: "/LICENSE"}}},
+		{"/about", false, "/about", nil},
+		{"/abo", false, "/:page", goa.Params{goa.Param{Key: "page", Value: "abo"}}},
+		{"/contact", false, "/:page", goa.Params{goa.Param{Key: "page", Value: "contact"}}},
+		{"/about/team", false, "/*filepath", goa.Params{goa.Param{Key: "filepath", Value: "/about/team"}}},
+		{"/", false, "/*filepath", goa.Params{goa.Param{Key: "filepath", Value: "/"}}},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+}
+
 func TestTreeDupliatePath(t *testing.T) {
 	tree := &node{}
 
@@ -278,7 +368,7 @@ func TestTreeDupliatePath(t *testing.T) {
 	}
 	for _, route := range routes {
 		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
+			addRoute(&tree, route, fakeHandler(route))
 		})
 		if recv != nil {
 			t.Fatalf("panic inserting route '%s': %v", route, recv)
@@ -286,7 +376,7 @@ func TestTreeDupliatePath(t *testing.T) {
 
 		// Add again
 		recv = catchPanic(func() {
-			tree.addRoute(route, nil)
+			addRoute(&tree, route, nil)
 		})
 		if recv == nil {
 			t.Fatalf("no panic while inserting duplicate route '%s", route)
@@ -313,7 +403,7 @@ func TestEmptyWildcardName(t *testing.T) {
 	}
 	for _, route := range routes {
 		recv := catchPanic(func() {
-			tree.addRoute(route, nil)
+			addRoute(&tree, route, nil)
 		})
 		if recv == nil {
 			t.Fatalf("no panic while inserting route with empty wildcard name '%s", route)
@@ -323,23 +413,26 @@ func TestEmptyWildcardName(t *testing.T) {
 
 func TestTreeCatchAllConflict(t *testing.T) {
 	routes := []testRoute{
-		{"/src/*filepath/x", true},
+		{"/src/*filepath/x", false},
 		{"/src2/", false},
-		{"/src2/*filepath/x", true},
+		{"/src2/*filepath/x", false},
+		{"/src/*filepath", false},
+		{"/src/*path/y", true},
+		{"/src/*path", true},
 	}
 	testRoutes(t, routes)
 }
 
-func TestTreeCatchAllConflictRoot(t *testing.T) {
+func TestTreeCatchAllRoot(t *testing.T) {
 	routes := []testRoute{
 		{"/", false},
-		{"/*filepath", true},
+		{"/*filepath", false},
 	}
 	testRoutes(t, routes)
 }
 
 func TestTreeDoubleWildcard(t *testing.T) {
-	const panicMsg = "only one wildcard per path segment is allowed"
+	const panicMsg = "wildcards in a path segment must be separated by static text"
 
 	routes := [...]string{
 		"/:foo:bar",
@@ -350,7 +443,7 @@ func TestTreeDoubleWildcard(t *testing.T) {
 	for _, route := range routes {
 		tree := &node{}
 		recv := catchPanic(func() {
-			tree.addRoute(route, nil)
+			addRoute(&tree, route, nil)
 		})
 
 		if rs, ok := recv.(string); !ok || !strings.HasPrefix(rs, panicMsg) {
@@ -390,7 +483,7 @@ func TestTreeTrailingSlashRedirect(t *testing.T) {
 	}
 	for _, route := range routes {
 		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
+			addRoute(&tree, route, fakeHandler(route))
 		})
 		if recv != nil {
 			t.Fatalf("panic inserting route '%s': %v", route, recv)
@@ -444,7 +537,7 @@ func TestTreeRootTrailingSlashRedirect(t *testing.T) {
 	tree := &node{}
 
 	recv := catchPanic(func() {
-		tree.addRoute("/:test", fakeHandler("/:test"))
+		addRoute(&tree, "/:test", fakeHandler("/:test"))
 	})
 	if recv != nil {
 		t.Fatalf("panic inserting test route: %v", recv)
@@ -484,11 +577,21 @@ func TestTreeFindCaseInsensitivePath(t *testing.T) {
 		"/doc/go/away",
 		"/no/a",
 		"/no/b",
+		"/u/apfêl/",
+		"/u/äpfêl/",
+		"/u/öpfêl",
+		"/v/Äpfêl/",
+		"/v/Öpfêl",
+		"/w/♬",  // 3 byte
+		"/w/♭/", // 3 byte, last byte differs
+		"/w/𠜎",  // 4 byte
+		"/w/𠜏/", // 4 byte
+		"/k",
 	}
 
 	for _, route := range routes {
 		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
+			addRoute(&tree, route, fakeHandler(route))
 		})
 		if recv != nil {
 			t.Fatalf("panic inserting route '%s': %v", route, recv)
@@ -562,6 +665,22 @@ func TestTreeFindCaseInsensitivePath(t *testing.T) {
 		{"/DOC/", "/doc", true, true},
 		{"/NO", "", false, true},
 		{"/DOC/GO", "", false, true},
+		{"/u/Apfêl/", "/u/apfêl/", true, false},
+		{"/u/ÄPFÊL/", "/u/äpfêl/", true, false},
+		{"/u/Äpfêl", "/u/äpfêl/", true, true},
+		{"/u/ÖPFÊL/", "/u/öpfêl", true, true},
+		{"/u/öpfêl", "/u/öpfêl", true, false},
+		{"/u/Öpfêl", "/u/öpfêl", true, false},
+		{"/V/äpfêl/", "/v/Äpfêl/", true, false},
+		{"/v/öpfêl", "/v/Öpfêl", true, false},
+		{"/u/üpfêl", "", false, false},
+		{"/w/♬/", "/w/♬", true, true},
+		{"/w/♭", "/w/♭/", true, true},
+		{"/w/♮", "", false, false},
+		{"/w/𠜎/", "/w/𠜎", true, true},
+		{"/w/𠜏", "/w/𠜏/", true, true},
+		{"/w/𠜐", "", false, false},
+		{"/\u212A", "/k", true, false}, // Kelvin sign
 	}
 	// With fixTrailingSlash = true
 	for _, test := range tests {
@@ -593,8 +712,8 @@ func TestTreeInvalidNodeType(t *testing.T) {
 	const panicMsg = "invalid node type"
 
 	tree := &node{}
-	tree.addRoute("/", fakeHandler("/"))
-	tree.addRoute("/:page", fakeHandler("/:page"))
+	addRoute(&tree, "/", fakeHandler("/"))
+	addRoute(&tree, "/:page", fakeHandler("/:page"))
 
 	// set invalid node type
 	tree.children[0].nType = 42
@@ -623,17 +742,12 @@ func TestTreeWildcardConflictEx(t *testing.T) {
 		existPath    string
 		existSegPath string
 	}{
-		{"/who/are/foo", "/foo", `/who/are/\*you`, `/\*you`},
-		{"/who/are/foo/", "/foo/", `/who/are/\*you`, `/\*you`},
-		{"/who/are/foo/bar", "/foo/bar", `/who/are/\*you`, `/\*you`},
-		{"/conxxx", "xxx", `/con:tact`, `:tact`},
-		{"/conooo/xxx", "ooo", `/con:tact`, `:tact`},
+		{"/who/are/*me", `/\*me`, `/who/are/\*you`, `/\*you`},
+		{"/con:other", ":other", `/con:tact`, `:tact`},
+		{"/con:other/xxx", ":other", `/con:tact`, `:tact`},
 	}
 
 	for _, conflict := range conflicts {
-		// I have to re-create a 'tree', because the 'tree' will be
-		// in an inconsistent state when the loop recovers from the
-		// panic which threw by 'addRoute' function.
 		tree := &node{}
 		routes := [...]string{
 			"/con:tact",
@@ -642,11 +756,11 @@ func TestTreeWildcardConflictEx(t *testing.T) {
 		}
 
 		for _, route := range routes {
-			tree.addRoute(route, fakeHandler(route))
+			addRoute(&tree, route, fakeHandler(route))
 		}
 
 		recv := catchPanic(func() {
-			tree.addRoute(conflict.route, fakeHandler(conflict.route))
+			addRoute(&tree, conflict.route, fakeHandler(conflict.route))
 		})
 
 		if !regexp.MustCompile(fmt.Sprintf("'%s' in new path .* conflicts with existing wildcard '%s' in existing prefix '%s'", conflict.segPath, conflict.existSegPath, conflict.existPath)).MatchString(fmt.Sprint(recv)) {
@@ -654,3 +768,466 @@ func TestTreeWildcardConflictEx(t *testing.T) {
 		}
 	}
 }
+
+func TestTreeConstraints(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/users/:id<int>",
+		"/users/:id<int>/posts",
+		"/files/:name<[a-z0-9-]+>",
+		"/items/:id<uuid>",
+		"/tags/:tag<alpha>",
+		"/days/:day<date>/events",
+		"/stars/:n<\\d*>",
+	}
+	for _, route := range routes {
+		recv := catchPanic(func() {
+			addRoute(&tree, route, fakeHandler(route))
+		})
+		if recv != nil {
+			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		}
+	}
+
+	checkRequests(t, tree, testRequests{
+		{"/users/42", false, "/users/:id<int>", goa.Params{goa.Param{Key: "id", Value: "42"}}},
+		{"/users/-1", false, "/users/:id<int>", goa.Params{goa.Param{Key: "id", Value: "-1"}}},
+		{"/users/abc", true, "", nil},
+		{"/users/42/posts", false, "/users/:id<int>/posts", goa.Params{goa.Param{Key: "id", Value: "42"}}},
+		{"/users/abc/posts", true, "", nil},
+		{"/files/go-1", false, "/files/:name<[a-z0-9-]+>", goa.Params{goa.Param{Key: "name", Value: "go-1"}}},
+		{"/files/Go_1", true, "", nil},
+		{"/items/123e4567-e89b-12d3-a456-426614174000", false, "/items/:id<uuid>", goa.Params{goa.Param{Key: "id", Value: "123e4567-e89b-12d3-a456-426614174000"}}},
+		{"/items/123e4567", true, "", nil},
+		{"/tags/golang", false, "/tags/:tag<alpha>", goa.Params{goa.Param{Key: "tag", Value: "golang"}}},
+		{"/tags/go1", true, "", nil},
+		{"/days/2019-02-28/events", false, "/days/:day<date>/events", goa.Params{goa.Param{Key: "day", Value: "2019-02-28"}}},
+		{"/days/2019-02-30/events", true, "", nil},
+		{"/stars/123", false, "/stars/:n<\\d*>", goa.Params{goa.Param{Key: "n", Value: "123"}}},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+
+	// a failing constraint must not produce a case-insensitive match either
+	if _, found := tree.findCaseInsensitivePath("/USERS/abc", true); found {
+		t.Error("case-insensitive lookup matched a value failing its constraint")
+	}
+	if out, found := tree.findCaseInsensitivePath("/USERS/42", true); !found || string(out) != "/users/42" {
+		t.Errorf("wrong case-insensitive result: got %s, %t", string(out), found)
+	}
+}
+
+func TestTreeInvalidConstraints(t *testing.T) {
+	routes := [...]string{
+		"/users/:id<int",
+		"/users/:id<int>x",
+		"/users/:id<[a-z>",
+		"/users/:<int>",
+		"/src/*filepath<int>",
+	}
+	for _, route := range routes {
+		tree := &node{}
+		recv := catchPanic(func() {
+			addRoute(&tree, route, nil)
+		})
+		if recv == nil {
+			t.Errorf("no panic while inserting route with invalid constraint '%s'", route)
+		}
+	}
+
+	// params with different constraints share a parent, params with the
+	// same constraint conflict
+	testRoutes(t, []testRoute{
+		{"/users/:id<int>", false},
+		{"/users/:id<alpha>", false},
+		{"/users/:id", false},
+		{"/users/:name<alpha>", true},
+		{"/users/:name", true},
+	})
+}
+
+func TestTreeMultipleParams(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/files/:name",
+		"/files/:name.:ext",
+		"/files/:name.:ext/meta",
+		"/files/:name-v:version",
+		"/v:major<int>.:minor<int>/status",
+		"/v:major<int>/status",
+		"/range/:from-:to",
+	}
+	for _, route := range routes {
+		recv := catchPanic(func() {
+			addRoute(&tree, route, fakeHandler(route))
+		})
+		if recv != nil {
+			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		}
+	}
+
+	checkRequests(t, tree, testRequests{
+		{"/files/readme", false, "/files/:name", goa.Params{goa.Param{Key: "name", Value: "readme"}}},
+		{"/files/main.go", false, "/files/:name.:ext", goa.Params{goa.Param{Key: "name", Value: "main"}, goa.Param{Key: "ext", Value: "go"}}},
+		// a param ends at the first delimiter
+		{"/files/a.tar.gz", false, "/files/:name.:ext", goa.Params{goa.Param{Key: "name", Value: "a"}, goa.Param{Key: "ext", Value: "tar.gz"}}},
+		{"/files/main.go/meta", false, "/files/:name.:ext/meta", goa.Params{goa.Param{Key: "name", Value: "main"}, goa.Param{Key: "ext", Value: "go"}}},
+		{"/files/tool-v2", false, "/files/:name-v:version", goa.Params{goa.Param{Key: "name", Value: "tool"}, goa.Param{Key: "version", Value: "2"}}},
+		// the delimiter is the whole static text following the param
+		{"/files/my-tool-v2", false, "/files/:name-v:version", goa.Params{goa.Param{Key: "name", Value: "my-tool"}, goa.Param{Key: "version", Value: "2"}}},
+		// other occurrences of the delimiter are not tried
+		{"/files/a-v1-v2", false, "/files/:name-v:version", goa.Params{goa.Param{Key: "name", Value: "a"}, goa.Param{Key: "version", Value: "1-v2"}}},
+		// delimiters need a value on both sides
+		{"/files/.go", false, "/files/:name", goa.Params{goa.Param{Key: "name", Value: ".go"}}},
+		{"/files/main.", false, "/files/:name", goa.Params{goa.Param{Key: "name", Value: "main."}}},
+		{"/v1.2/status", false, "/v:major<int>.:minor<int>/status", goa.Params{goa.Param{Key: "major", Value: "1"}, goa.Param{Key: "minor", Value: "2"}}},
+		{"/v1/status", false, "/v:major<int>/status", goa.Params{goa.Param{Key: "major", Value: "1"}}},
+		{"/v1.x/status", true, "", nil},
+		{"/range/1-10", false, "/range/:from-:to", goa.Params{goa.Param{Key: "from", Value: "1"}, goa.Param{Key: "to", Value: "10"}}},
+		{"/range/1", true, "", nil},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+
+	if out, found := tree.findCaseInsensitivePath("/FILES/Main.Go", true); !found || string(out) != "/files/Main.Go" {
+		t.Errorf("wrong case-insensitive result: got %s, %t", string(out), found)
+	}
+
+	// params at the same position must have the same name, whatever follows
+	// them in the segment
+	testRoutes(t, []testRoute{
+		{"/files/:name.:ext", false},
+		{"/files/:name", false},
+		{"/files/:name-:version", false},
+		{"/files/:file", true},
+		{"/files/:file.:ext", true},
+		{"/files/:name.:format", true},
+		{"/files/:name.json", false},
+		{"/files/x:name", false},
+	})
+
+	invalid := [...]string{
+		"/files/:name:ext",
+		"/files/:name*ext",
+		"/files/:name<int>x",
+	}
+	for _, route := range invalid {
+		tree := &node{}
+		if recv := catchPanic(func() { addRoute(&tree, route, nil) }); recv == nil {
+			t.Errorf("no panic while inserting invalid route '%s'", route)
+		}
+	}
+}
+
+func TestTreeDelimiterBacktracking(t *testing.T) {
+	tree := &node{}
+	routes := [...]string{
+		"/v:major.:minor.:patch/status",
+		"/files/:name-:version.:ext",
+		"/a/*x/b/*y/c",
+	}
+	for _, route := range routes {
+		addRoute(&tree, route, fakeHandler(route))
+	}
+
+	// each delimiter is tried once, backtracking must not take polynomial
+	// time in the length of the path
+	const n = 100000
+	paths := [...]string{
+		"/v" + strings.Repeat(".", n) + "/nope",
+		"/files/" + strings.Repeat("-", n),
+		"/a" + strings.Repeat("/b", n) + "/d",
+	}
+	for _, path := range paths {
+		start := time.Now()
+		if leaf, _ := tree.find(path, nil, false); leaf != nil {
+			t.Errorf("%.20s...: matched %s", path, leaf.fullPath)
+		}
+		if leaf, _ := tree.find(path, nil, true); leaf != nil {
+			t.Errorf("%.20s... (ci): matched %s", path, leaf.fullPath)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%.20s...: lookups took %v", path, d)
+		}
+	}
+}
+
+func TestTreeMidPathCatchAll(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/repos/*path/blob/:ref",
+		"/repos/*path/tree/:ref",
+		"/repos/*path",
+		"/repos/:owner/settings",
+		"/assets/*file.map",
+		"/assets/*file",
+		"/a/*x/b/*y/c",
+	}
+	for _, route := range routes {
+		recv := catchPanic(func() {
+			addRoute(&tree, route, fakeHandler(route))
+		})
+		if recv != nil {
+			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		}
+	}
+
+	checkRequests(t, tree, testRequests{
+		{"/repos/goa/router/blob/master", false, "/repos/*path/blob/:ref", goa.Params{goa.Param{Key: "path", Value: "/goa/router"}, goa.Param{Key: "ref", Value: "master"}}},
+		{"/repos/goa/router/tree/v1", false, "/repos/*path/tree/:ref", goa.Params{goa.Param{Key: "path", Value: "/goa/router"}, goa.Param{Key: "ref", Value: "v1"}}},
+		// the catch-all ends at the last occurrence of the suffix
+		{"/repos/a/blob/b/blob/c", false, "/repos/*path/blob/:ref", goa.Params{goa.Param{Key: "path", Value: "/a/blob/b"}, goa.Param{Key: "ref", Value: "c"}}},
+		{"/repos/a/blob/b/c", false, "/repos/*path", goa.Params{goa.Param{Key: "path", Value: "/a/blob/b/c"}}},
+		{"/repos/goa/blob/", false, "/repos/*path", goa.Params{goa.Param{Key: "path", Value: "/goa/blob/"}}},
+		// regular routes take priority
+		{"/repos/goa/settings", false, "/repos/:owner/settings", goa.Params{goa.Param{Key: "owner", Value: "goa"}}},
+		{"/repos/goa/x/settings", false, "/repos/*path", goa.Params{goa.Param{Key: "path", Value: "/goa/x/settings"}}},
+		{"/assets/js/app.js.map", false, "/assets/*file.map", goa.Params{goa.Param{Key: "file", Value: "/js/app.js"}}},
+		{"/assets/js/app.js", false, "/assets/*file", goa.Params{goa.Param{Key: "file", Value: "/js/app.js"}}},
+		{"/a/1/2/b/3/c", false, "/a/*x/b/*y/c", goa.Params{goa.Param{Key: "x", Value: "/1/2"}, goa.Param{Key: "y", Value: "/3"}}},
+		{"/a/1/b/2/c/x", true, "", nil},
+	})
+
+	// a catch-all followed by another one would take the whole rest of the
+	// path, so that the route could never match
+	for _, route := range []string{"/b/*x/*y", "/a/*x/*y"} {
+		if _, _, err := tree.insertRoute(route, fakeHandler(route)); err == nil {
+			t.Errorf("no error for catch-all route '%s'", route)
+		} else if _, ok := err.(*InvalidPatternError); !ok {
+			t.Errorf("expected *InvalidPatternError for '%s', got %#v", route, err)
+		}
+	}
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+
+	if out, found := tree.findCaseInsensitivePath("/REPOS/Goa/Router/BLOB/master", true); !found || string(out) != "/repos/Goa/Router/blob/master" {
+		t.Errorf("wrong case-insensitive result: got %s, %t", string(out), found)
+	}
+	if _, _, tsr := tree.lookup("/a/1/b/2/c/"); !tsr {
+		t.Error("no trailing slash redirect recommendation for a mid-path catch-all route")
+	}
+
+	// removing a suffix route keeps the catch-all route
+	root, leaf := tree.remove("/repos/*path/blob/:ref")
+	if leaf == nil {
+		t.Fatal("mid-path catch-all route not removed")
+	}
+	checkRequests(t, root, testRequests{
+		{"/repos/goa/router/blob/master", false, "/repos/*path", goa.Params{goa.Param{Key: "path", Value: "/goa/router/blob/master"}}},
+		{"/repos/goa/router/tree/v1", false, "/repos/*path/tree/:ref", goa.Params{goa.Param{Key: "path", Value: "/goa/router"}, goa.Param{Key: "ref", Value: "v1"}}},
+	})
+}
+
+func TestTreeRejectedRoute(t *testing.T) {
+	tree := &node{}
+	addRoute(&tree, "/users/:id", fakeHandler("/users/:id"))
+	addRoute(&tree, "/users/new", fakeHandler("/users/new"))
+
+	routes := [...]string{
+		"/users/:name/edit",
+		"/users/new",
+		"/users/*x:y",
+	}
+	for _, route := range routes {
+		if recv := catchPanic(func() {
+			addRoute(&tree, route, fakeHandler(route))
+		}); recv == nil {
+			t.Fatalf("no panic while inserting route '%s'", route)
+		}
+	}
+
+	// the tree is left untouched by rejected routes
+	checkRequests(t, tree, testRequests{
+		{"/users/new", false, "/users/new", nil},
+		{"/users/42", false, "/users/:id", goa.Params{goa.Param{Key: "id", Value: "42"}}},
+		{"/users/42/edit", true, "", nil},
+	})
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+}
+
+func TestTreeFullPath(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/",
+		"/users/:id<int>",
+		"/users/:id/posts",
+		"/users/new",
+		"/src/*filepath",
+	}
+	for _, route := range routes {
+		if leaf := addRoute(&tree, route, fakeHandler(route)); leaf.fullPath != route {
+			t.Errorf("wrong full path of the leaf of '%s': %s", route, leaf.fullPath)
+		}
+	}
+
+	for path, route := range map[string]string{
+		"/":              "/",
+		"/users/42":      "/users/:id<int>",
+		"/users/x/posts": "/users/:id/posts",
+		"/users/new":     "/users/new",
+		"/src/a/b":       "/src/*filepath",
+	} {
+		leaf, _, _ := tree.lookup(path)
+		if leaf == nil {
+			t.Errorf("no leaf found for '%s'", path)
+		} else if leaf.fullPath != route {
+			t.Errorf("wrong full path for '%s': want %s, got %s", path, route, leaf.fullPath)
+		}
+	}
+}
+
+// dumpTree returns the structure of the tree, without handlers. Static
+// children with the same priority may be ordered differently depending on
+// the order of registrations, so they are sorted by path.
+func dumpTree(n *node, indent string) string {
+	indices := []byte(n.indices)
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	s := fmt.Sprintf("%s%q type=%d wild=%t indices=%q prio=%d maxParams=%d leaf=%t\n",
+		indent, n.path, n.nType, n.wildChild, indices, n.priority, n.maxParams, n.handler != nil)
+
+	statics := append([]*node(nil), n.children[:len(n.indices)]...)
+	sort.Slice(statics, func(i, j int) bool { return statics[i].path < statics[j].path })
+	for _, child := range append(statics, n.children[len(n.indices):]...) {
+		s += dumpTree(child, indent+"  ")
+	}
+	return s
+}
+
+// checkChildOrder checks that the static children are ordered by priority
+// and match their indices.
+func checkChildOrder(t *testing.T, n *node) {
+	for i := range n.indices {
+		child := n.children[i]
+		if child.path[0] != n.indices[i] {
+			t.Errorf("index mismatch for node '%s': index %q, child '%s'", n.path, n.indices[i], child.path)
+		}
+		if i > 0 && n.children[i-1].priority < child.priority {
+			t.Errorf("children of node '%s' not ordered by priority", n.path)
+		}
+	}
+	for _, child := range n.children {
+		checkChildOrder(t, child)
+	}
+}
+
+func TestTreeRemove(t *testing.T) {
+	routes := []string{
+		"/",
+		"/cmd/:tool/:sub",
+		"/cmd/:tool/",
+		"/src/*filepath",
+		"/search/",
+		"/search/:query",
+		"/user_:name",
+		"/user_:name/about",
+		"/files/:dir/*filepath",
+		"/doc/",
+		"/doc/go_faq.html",
+		"/doc/go1.html",
+		"/info/:user/public",
+		"/info/:user/project/:project",
+		"/users/:id<int>",
+		"/users/:id",
+		"/users/new",
+	}
+	build := func(routes []string) *node {
+		tree := &node{}
+		for _, route := range routes {
+			addRoute(&tree, route, fakeHandler(route))
+		}
+		return tree
+	}
+
+	for i, removed := range routes {
+		tree := build(routes)
+		before := dumpTree(tree, "")
+
+		root, leaf := tree.remove(removed)
+		if leaf == nil || leaf.fullPath != removed {
+			t.Errorf("route '%s' was not removed", removed)
+			continue
+		}
+		if dumpTree(tree, "") != before {
+			t.Errorf("removing '%s' modified the original tree", removed)
+		}
+
+		// the compacted tree equals a tree built without the route
+		rest := append(append([]string(nil), routes[:i]...), routes[i+1:]...)
+		if got, want := dumpTree(root, ""), dumpTree(build(rest), ""); got != want {
+			t.Errorf("wrong tree after removing '%s':\n%s\nwant:\n%s", removed, got, want)
+		}
+		checkPriorities(t, root)
+		checkMaxParams(t, root)
+		checkChildOrder(t, root)
+
+		for _, route := range rest {
+			if strings.HasPrefix(route, "/users/:id") {
+				// the wildcards can't be requested literally
+				continue
+			}
+			if leaf, _, _ := root.lookup(route); leaf == nil || leaf.fullPath != route {
+				t.Errorf("route '%s' not found after removing '%s'", route, removed)
+			}
+		}
+	}
+
+	// unknown routes
+	tree := build(routes)
+	for _, route := range []string{"/cmd/:tool", "/users/:id<alpha>", "/doc", "/src/*path", "/x"} {
+		if root, leaf := tree.remove(route); leaf != nil || root != tree {
+			t.Errorf("unregistered route '%s' was removed", route)
+		}
+	}
+
+	// removing the last route empties the tree
+	tree = build([]string{"/a"})
+	if root, leaf := tree.remove("/a"); leaf == nil || root != nil {
+		t.Errorf("tree not empty after removing the last route")
+	}
+}
+
+func TestTreeFindFold(t *testing.T) {
+	tree := &node{}
+	routes := [...]string{
+		"/users/:id",
+		"/users/new",
+		"/Docs/*filepath",
+		"/about/",
+	}
+	for _, route := range routes {
+		addRoute(&tree, route, fakeHandler(route))
+	}
+
+	tests := []struct {
+		path  string
+		route string
+		ps    goa.Params
+		tsr   bool
+	}{
+		{"/USERS/New", "/users/new", nil, false},
+		{"/Users/Gopher", "/users/:id", goa.Params{{Key: "id", Value: "Gopher"}}, false},
+		{"/docs/A/b", "/Docs/*filepath", goa.Params{{Key: "filepath", Value: "/A/b"}}, false},
+		{"/ABOUT", "", nil, true},
+		{"/nope", "", nil, false},
+	}
+	for _, test := range tests {
+		var ps goa.Params
+		leaf, tsr := tree.find(test.path, &ps, true)
+		route := ""
+		if leaf != nil {
+			route = leaf.fullPath
+		}
+		if route != test.route || !reflect.DeepEqual(ps, test.ps) || tsr != test.tsr {
+			t.Errorf("find(%q) = %q, %v, %t; want %q, %v, %t",
+				test.path, route, ps, tsr, test.route, test.ps, test.tsr)
+		}
+	}
+}
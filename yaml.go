@@ -0,0 +1,179 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonToYAML converts a JSON document to block style YAML, keeping the order
+// of object keys.
+func jsonToYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, v, 0)
+	// drop the line break in front of the top-level collection
+	return bytes.TrimPrefix(buf.Bytes(), []byte("\n")), nil
+}
+
+// yamlEntry is a key-value pair of a JSON object.
+type yamlEntry struct {
+	key   string
+	value interface{}
+}
+
+// yamlMap is a JSON object with its keys in order.
+type yamlMap []yamlEntry
+
+// decodeOrdered decodes the next JSON value, objects are decoded to yamlMap,
+// arrays to []interface{}.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m := yamlMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, yamlEntry{key.(string), value})
+		}
+		_, err = dec.Token()
+		return m, err
+
+	case json.Delim('['):
+		l := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, value)
+		}
+		_, err = dec.Token()
+		return l, err
+
+	default:
+		return tok, nil
+	}
+}
+
+// writeYAML writes v as block YAML, indented by indent spaces. Collections
+// start on a new line, scalars are written inline.
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) {
+	prefix := strings.Repeat(" ", indent)
+
+	switch v := v.(type) {
+	case yamlMap:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteByte('\n')
+		for _, entry := range v {
+			buf.WriteString(prefix + yamlString(entry.key) + ":")
+			writeYAML(buf, entry.value, indent+2)
+		}
+
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteByte('\n')
+		for _, item := range v {
+			// write the item as if it was nested one level deeper, then
+			// put the dash in front of its first line
+			var itemBuf bytes.Buffer
+			writeYAML(&itemBuf, item, indent+2)
+			s := itemBuf.String()
+			if strings.HasPrefix(s, "\n") {
+				s = strings.TrimPrefix(s[1:], prefix+"  ")
+				buf.WriteString(prefix + "- " + s)
+			} else {
+				buf.WriteString(prefix + "-" + s)
+			}
+		}
+
+	default:
+		buf.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	default:
+		panic("unexpected JSON value")
+	}
+}
+
+// yamlString returns s as plain YAML scalar if that is unambiguous, as double
+// quoted scalar otherwise.
+func yamlString(s string) string {
+	if needsQuotes(s) {
+		// JSON strings are valid double quoted YAML scalars
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s)
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	return s
+}
+
+// needsQuotes reports whether s must be quoted to be read as string. Besides
+// strings containing indicators, these are the strings which YAML 1.1 or 1.2
+// may resolve to another type. Numbers, dates and timestamps in all their
+// notations (0x1F, 0o17, 1_000, 190:20:30, .inf, 2019-01-01) start with a
+// digit, a sign or a dot, so all such strings are quoted.
+func needsQuotes(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return true
+	}
+
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~", "=", "<<":
+		return true
+	}
+	if c := s[0]; '0' <= c && c <= '9' || c == '+' || c == '.' {
+		return true
+	}
+
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}